@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"PickupStats/docs"
 	"PickupStats/pkg/api"
@@ -10,6 +16,9 @@ import (
 	"PickupStats/pkg/db"
 	"PickupStats/pkg/frontend"
 	"PickupStats/pkg/logger"
+	"PickupStats/pkg/monitoring"
+	"PickupStats/pkg/resolver"
+	"PickupStats/src"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -18,10 +27,6 @@ import (
 	_ "PickupStats/docs"
 )
 
-const configPath = "config.yaml"
-
-const loglevel = "debug"
-
 var Version = "dev"
 
 // @title Pickup Stats API
@@ -29,30 +34,109 @@ var Version = "dev"
 
 // @BasePath /api
 func main() {
-	e := echo.New()
-	ctx := context.Background()
+	configPath := flag.String("config", "config.yaml", "path to config file, empty to configure with "+config.EnvPrefix+"* environment variables only")
+	addr := flag.String("addr", "", "listen address, overrides server.address")
+	logLevel := flag.String("log-level", "", "log level, overrides log.level")
+	logFormat := flag.String("log-format", "", "log format, text or json, overrides log.format")
+	frontendDir := flag.String("frontend-dir", "", "serve frontend from this directory instead of the embedded files, for frontend development")
+	flag.Parse()
 
-	l, err := logger.SetLogger(e, loglevel)
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to parse loglevel: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if *addr != "" {
+		cfg.Server.Address = *addr
+	}
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
 	}
+	if *logFormat != "" {
+		cfg.Log.Format = *logFormat
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	e := echo.New()
+	e.Server.ReadTimeout = cfg.Server.ReadTimeout
+	e.Server.WriteTimeout = cfg.Server.WriteTimeout
+	ctx := context.Background()
+	runCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	cfg, err := config.LoadConfig(configPath)
+	l, err := logger.SetLogger(e, cfg.Log.Level, cfg.Log.Format)
 	if err != nil {
-		l.Fatalf("Failed to parse config: %v", err)
+		log.Fatalf("Failed to set up logger: %v", err)
 	}
+	e.Use(middleware.RequestID())
+	e.Use(monitoring.Middleware())
+	e.HTTPErrorHandler = api.NewErrorHandler(e, l)
 
-	client, err := db.NewClient(ctx, cfg.DSN, cfg.Database, cfg.GameCollection, cfg.NameCollection)
+	client, err := db.NewClient(ctx, cfg.DSN, cfg.Database, cfg.GameCollection, cfg.NameCollection, cfg.SummaryCollection)
 	if err != nil {
 		l.Fatalf("Failed to conntect to mongodb: %v", err)
 	}
+	client.QueryTimeout = cfg.QueryTimeout
 
-	api.NewHandler(e, client)
-	frontend.NewHandler(e)
+	ratings := db.NewRatingCache(client, cfg.Cache.RatingsTTL)
+
+	go client.Players.Run(runCtx, cfg.Cache.PlayersRefresh, l)
+	go ratings.Run(runCtx, cfg.Cache.GamesCheck, l)
+
+	apiOpts := api.Options{MinGames: cfg.MinGames, CORSOrigins: cfg.Server.CORSOrigins, ZeroPolicy: db.ZeroPolicy(cfg.ZeroDenominator), Sites: cfg.SiteNames()}
+	if cfg.Resolver.Interval > 0 {
+		r := resolver.New(client.Conn.Database(cfg.Database).Collection(cfg.NameCollection), cfg.Sites, resolver.Fetcher{
+			Client:  &http.Client{Timeout: cfg.Resolver.Timeout},
+			Retries: cfg.Resolver.Retries,
+			Backoff: cfg.Resolver.Backoff,
+		})
+		go r.Run(runCtx, cfg.Resolver.Interval, l.WithField("component", "resolver"))
+		apiOpts.Resolver = r
+	}
+	api.NewHandler(e, ratings, apiOpts)
+	api.NewHealthHandler(e, client)
+	monitoring.RegisterGauges(monitoring.Gauges{
+		PlayersDirectorySize: func() float64 {
+			return float64(client.PlayersStatus().Size)
+		},
+		GamesCount: func() (float64, error) {
+			count, err := client.EstimateGamesCount(ctx)
+			return float64(count), err
+		},
+	})
+	e.GET("/metrics", echo.WrapHandler(monitoring.Handler()))
+	frontendOpts := frontend.Options{MinGames: cfg.MinGames, ZeroPolicy: apiOpts.ZeroPolicy}
+	if *frontendDir != "" {
+		frontendOpts.Dev = true
+		err = frontend.NewHandler(e, os.DirFS(*frontendDir), ratings, frontendOpts)
+	} else {
+		err = frontend.NewHandler(e, src.FS, ratings, frontendOpts)
+	}
+	if err != nil {
+		l.Fatalf("Failed to load frontend: %v", err)
+	}
 
 	docs.SwaggerInfo.Version = Version
 	e.Use(middleware.Recover())
 	e.GET("/docs/*", echoSwagger.WrapHandler)
 
-	e.Logger.Fatal(e.Start(":1323"))
+	go func() {
+		if err := e.Start(cfg.Server.Address); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-runCtx.Done()
+	stop()
+	l.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err = e.Shutdown(shutdownCtx); err != nil {
+		l.Errorf("Failed to drain requests: %v", err)
+	}
+	if err = client.Conn.Disconnect(shutdownCtx); err != nil {
+		l.Errorf("Failed to disconnect from mongodb: %v", err)
+	}
 }
@@ -40,13 +40,49 @@ var doc = `{
                         "type": "string",
                         "description": "Player class",
                         "name": "class",
-                        "in": "path"
+                        "in": "query"
                     },
                     {
                         "type": "integer",
                         "description": "Minimum games played",
                         "name": "mingames",
-                        "in": "path"
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Shortcut time window: 7d, 30d or season",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played since this date (YYYY-MM-DD or RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, all players by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of players to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only rate players of this tf2pickup site, e.g. ru",
+                        "name": "site",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -56,6 +92,9 @@ var doc = `{
                             "$ref": "#/definitions/api.Response"
                         }
                     },
+                    "304": {
+                        "description": "Not Modified"
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -67,6 +106,12 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -95,6 +140,12 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -112,11 +163,53 @@ var doc = `{
                 ],
                 "summary": "Medics rating by average heals given per minute.",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Player class",
+                        "name": "class",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "description": "Minimum games played",
                         "name": "mingames",
-                        "in": "path"
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Shortcut time window: 7d, 30d or season",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played since this date (YYYY-MM-DD or RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, all players by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of players to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only rate players of this tf2pickup site, e.g. ru",
+                        "name": "site",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -126,6 +219,9 @@ var doc = `{
                             "$ref": "#/definitions/api.Response"
                         }
                     },
+                    "304": {
+                        "description": "Not Modified"
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -137,6 +233,12 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -158,13 +260,49 @@ var doc = `{
                         "type": "string",
                         "description": "Player class",
                         "name": "class",
-                        "in": "path"
+                        "in": "query"
                     },
                     {
                         "type": "integer",
                         "description": "Minimum games played",
                         "name": "mingames",
-                        "in": "path"
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Shortcut time window: 7d, 30d or season",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played since this date (YYYY-MM-DD or RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, all players by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of players to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only rate players of this tf2pickup site, e.g. ru",
+                        "name": "site",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -174,6 +312,9 @@ var doc = `{
                             "$ref": "#/definitions/api.Response"
                         }
                     },
+                    "304": {
+                        "description": "Not Modified"
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -185,62 +326,704 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "api.ErrorResponse": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
+        },
+        "/players/search": {
+            "get": {
+                "description": "Case-insensitive prefix, substring and typo-tolerant matching, best matches first.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Players"
+                ],
+                "summary": "Find players by current or previous names.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Part of a name, at least 2 characters",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of players, 10 by default",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SearchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "api.GamesCount": {
-            "type": "object",
-            "properties": {
-                "count": {
-                    "type": "integer"
+        "/players/{steamid}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Players"
+                ],
+                "summary": "Player profile with stats broken down per class.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Player SteamID64, SteamID3 ([U:1:x], URL-encoded) or STEAM_X:Y:Z",
+                        "name": "steamid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.Profile"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "api.Response": {
-            "type": "object",
-            "properties": {
-                "stats": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/db.Result"
+        "/players/{steamid}/games": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Players"
+                ],
+                "summary": "Player match history, newest first.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Player SteamID64, SteamID3 ([U:1:x], URL-encoded) or STEAM_X:Y:Z",
+                        "name": "steamid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor returned as next_cursor by the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.GamesPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "db.Result": {
-            "type": "object",
-            "properties": {
-                "avatar": {
-                    "type": "string"
-                },
-                "dpm": {
-                    "type": "number"
-                },
-                "games": {
-                    "type": "integer"
-                },
-                "hpm": {
-                    "type": "number"
-                },
-                "kdr": {
-                    "type": "number"
-                },
-                "player_name": {
-                    "type": "string"
-                },
-                "steamid64": {
-                    "type": "string"
+        "/ratings/{metric}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Ratings"
+                ],
+                "summary": "Player rating by any registered metric.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric name: dpm, kdr, hpm, dtm, assists, kad, ubers, drops, uber_build_time, airshots or captures",
+                        "name": "metric",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Player class",
+                        "name": "class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum games played",
+                        "name": "mingames",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Shortcut time window: 7d, 30d or season",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played since this date (YYYY-MM-DD or RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, all players by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of players to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only rate players of this tf2pickup site, e.g. ru",
+                        "name": "site",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.Response"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/status/data-quality": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Util"
+                ],
+                "summary": "Malformed documents met while serving queries, skipped in results.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.QualityReport"
+                        }
+                    }
+                }
+            }
+        },
+        "/status/players": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Util"
+                ],
+                "summary": "Size and age of the in-memory player names directory.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.DirectoryStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/status/resolver": {
+            "get": {
+                "description": "Interval is zero and the sync never happened when the server doesn't sync players.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Util"
+                ],
+                "summary": "Time and outcome of the last player sync run by the server.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/resolver.Status"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "enum": [
+                        "bad_class",
+                        "bad_min_games",
+                        "bad_steamid",
+                        "bad_time_window",
+                        "bad_page",
+                        "bad_site",
+                        "bad_query",
+                        "bad_request",
+                        "not_found",
+                        "upstream_timeout",
+                        "internal"
+                    ]
+                },
+                "error": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "description": "RequestID matches the X-Request-ID header and server logs.",
+                    "type": "string"
+                }
+            }
+        },
+        "api.GamesCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.GamesPage": {
+            "type": "object",
+            "properties": {
+                "games": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.Game"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.RatingMeta": {
+            "type": "object",
+            "properties": {
+                "metric": {
+                    "type": "string"
+                },
+                "site": {
+                    "description": "Site the rating is limited to, empty for every site.",
+                    "type": "string"
+                },
+                "zero_denominator": {
+                    "description": "ZeroDenominator tells what happens to players whose denominator is zero,\ne.g. who never died for KDR: skip, one (divide by one) or null.",
+                    "type": "string"
+                }
+            }
+        },
+        "api.Response": {
+            "type": "object",
+            "properties": {
+                "meta": {
+                    "$ref": "#/definitions/api.RatingMeta"
+                },
+                "stats": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.Result"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.SearchResponse": {
+            "type": "object",
+            "properties": {
+                "players": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.PlayerMatch"
+                    }
+                }
+            }
+        },
+        "db.ClassStats": {
+            "type": "object",
+            "properties": {
+                "class": {
+                    "type": "string"
+                },
+                "dpm": {
+                    "type": "number"
+                },
+                "games": {
+                    "type": "integer"
+                },
+                "hpm": {
+                    "type": "number"
+                },
+                "kdr": {
+                    "type": "number"
+                },
+                "playtime": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.DirectoryStatus": {
+            "type": "object",
+            "properties": {
+                "age_seconds": {
+                    "type": "number"
+                },
+                "loaded_at": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.DocumentIssue": {
+            "type": "object",
+            "properties": {
+                "collection": {
+                    "type": "string"
+                },
+                "problem": {
+                    "type": "string"
+                },
+                "seen_at": {
+                    "type": "string"
+                },
+                "steamid": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.Game": {
+            "type": "object",
+            "properties": {
+                "class": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "length": {
+                    "type": "integer"
+                },
+                "log_id": {
+                    "type": "integer"
+                },
+                "stats": {
+                    "$ref": "#/definitions/db.GameStats"
+                }
+            }
+        },
+        "db.GameStats": {
+            "type": "object",
+            "properties": {
+                "airshots": {
+                    "type": "integer"
+                },
+                "assists": {
+                    "type": "integer"
+                },
+                "captures": {
+                    "type": "integer"
+                },
+                "damage_done": {
+                    "type": "integer"
+                },
+                "damage_taken": {
+                    "type": "integer"
+                },
+                "deaths": {
+                    "type": "integer"
+                },
+                "drops": {
+                    "type": "integer"
+                },
+                "healed": {
+                    "type": "integer"
+                },
+                "kills": {
+                    "type": "integer"
+                },
+                "uber_build_time": {
+                    "type": "number"
+                },
+                "ubers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.PlayerMatch": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string"
+                },
+                "matched_name": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "steamid64": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.Profile": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string"
+                },
+                "classes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.ClassStats"
+                    }
+                },
+                "first_game": {
+                    "type": "string"
+                },
+                "games": {
+                    "type": "integer"
+                },
+                "last_game": {
+                    "type": "string"
+                },
+                "player_name": {
+                    "type": "string"
+                },
+                "playtime": {
+                    "type": "integer"
+                },
+                "steamid64": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.QualityReport": {
+            "type": "object",
+            "properties": {
+                "issues": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.DocumentIssue"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.Result": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string"
+                },
+                "games": {
+                    "type": "integer"
+                },
+                "metric": {
+                    "type": "string"
+                },
+                "player_name": {
+                    "type": "string"
+                },
+                "rank": {
+                    "type": "integer"
+                },
+                "steamid3": {
+                    "description": "SteamID3 is the [U:1:x] form shown by logs.tf and the game console.",
+                    "type": "string"
+                },
+                "steamid64": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "number"
+                }
+            }
+        },
+        "resolver.Status": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Error of the last sync, empty when it succeeded.",
+                    "type": "string"
+                },
+                "interval_seconds": {
+                    "description": "IntervalSeconds between syncs, zero when the server doesn't sync players.",
+                    "type": "number"
+                },
+                "last_success": {
+                    "type": "string"
+                },
+                "last_sync": {
+                    "description": "LastSync is when the last sync finished, successfully or not.",
+                    "type": "string"
+                },
+                "summary": {
+                    "$ref": "#/definitions/resolver.Summary"
+                }
+            }
+        },
+        "resolver.Summary": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer"
+                },
+                "flagged": {
+                    "type": "integer"
+                },
+                "inserted": {
+                    "type": "integer"
+                },
+                "removed": {
+                    "description": "Removed counts duplicate documents deleted.",
+                    "type": "integer"
+                },
+                "skipped": {
+                    "description": "Skipped counts documents left alone because their steam_id can't be read.",
+                    "type": "integer"
+                },
+                "unchanged": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
                 }
             }
         }
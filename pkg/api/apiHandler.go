@@ -1,19 +1,40 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"PickupStats/pkg/db"
+	"PickupStats/pkg/resolver"
+	"PickupStats/pkg/steamid"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-const defaultMinGamesAmount = 10
+const (
+	defaultGamesPageSize = 20
+	maxGamesPageSize     = 100
+	maxRatingPageSize    = 500
+	defaultSearchLimit   = 10
+	maxSearchLimit       = 50
+	minSearchLength      = 2
+)
 
-var ErrBadClass = fmt.Errorf("invalid player class: must be scout, soldier, demoman or medic")
+var (
+	ErrBadClass       = fmt.Errorf("invalid player class: must be scout, soldier, demoman or medic")
+	ErrBadMetricClass = errors.New("invalid player class for this metric")
+	ErrBadLimit       = errors.New("invalid limit")
+	ErrBadOffset      = errors.New("invalid offset: must be a non-negative integer")
+	ErrBadMinGames    = errors.New("invalid mingames: must be a non-negative integer")
+	ErrBadSite        = errors.New("unknown site")
+	ErrBadQuery       = fmt.Errorf("invalid q: must be at least %d characters", minSearchLength)
+)
 
 type GamesCount struct {
 	Count int64 `json:"count"`
@@ -21,26 +42,107 @@ type GamesCount struct {
 
 type Response struct {
 	Stats []db.Result `json:"stats"`
+	Total int         `json:"total"`
+	Meta  RatingMeta  `json:"meta"`
+}
+
+// RatingMeta describes how a rating was computed.
+type RatingMeta struct {
+	Metric string `json:"metric"`
+	// Site the rating is limited to, empty for every site.
+	Site string `json:"site,omitempty"`
+	// ZeroDenominator tells what happens to players whose denominator is zero,
+	// e.g. who never died for KDR: skip, one (divide by one) or null.
+	ZeroDenominator db.ZeroPolicy `json:"zero_denominator"`
+}
+
+type SearchResponse struct {
+	Players []db.PlayerMatch `json:"players"`
+}
+
+type GamesPage struct {
+	Games      []db.Game `json:"games"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Options tune the API for a deployment.
+type Options struct {
+	// MinGames is used for ratings when the request doesn't set mingames.
+	MinGames int
+	// CORSOrigins allowed to call the API, any origin when empty.
+	CORSOrigins []string
+	// ZeroPolicy applies to ratios with a zero denominator, empty means db.ZeroSkip.
+	ZeroPolicy db.ZeroPolicy
+	// Sites ratings can be filtered by, see config.Config.SiteNames.
+	Sites []string
+	// Resolver reports player syncs run by the server, nil when it doesn't run them.
+	Resolver SyncStatus
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+// SyncStatus is implemented by resolver.Resolver.
+type SyncStatus interface {
+	Status() resolver.Status
 }
 
 type Handler struct {
-	mongo *db.Client
+	store      db.StatsStore
+	minGames   int
+	zeroPolicy db.ZeroPolicy
+	sites      []string
+	resolver   SyncStatus
 }
 
-func NewHandler(e *echo.Echo, mongo *db.Client) {
-	h := &Handler{mongo: mongo}
+// NewHandler registers API routes. Ratings support conditional requests
+// when the store is db.Versioned, e.g. db.RatingCache.
+func NewHandler(e *echo.Echo, store db.StatsStore, opts Options) {
+	h := &Handler{store: store, minGames: opts.MinGames, zeroPolicy: opts.ZeroPolicy, sites: opts.Sites, resolver: opts.Resolver}
+	if h.zeroPolicy == "" {
+		h.zeroPolicy = db.ZeroSkip
+	}
 
 	api := e.Group("/api")
 
-	api.Use(middleware.CORS())
+	cors := middleware.DefaultCORSConfig
+	if len(opts.CORSOrigins) > 0 {
+		cors.AllowOrigins = opts.CORSOrigins
+	}
+	api.Use(middleware.CORSWithConfig(cors))
+	api.GET("/ratings/:metric", h.Rating)
 	api.GET("/dpm", h.AverageDPM)
 	api.GET("/kdr", h.AverageKDR)
 	api.GET("/hpm", h.AverageHealPerMin)
 	api.GET("/gamesCount", h.GamesCount)
+	api.GET("/status/players", h.PlayersStatus)
+	api.GET("/status/data-quality", h.DataQuality)
+	api.GET("/status/resolver", h.ResolverStatus)
+	api.GET("/players/search", h.SearchPlayers)
+	api.GET("/players/:steamid", h.PlayerProfile)
+	api.GET("/players/:steamid/games", h.PlayerGames)
+}
+
+// Rating godoc
+// @Summary Player rating by any registered metric.
+// @Tags Ratings
+// @Accept */*
+// @Produce json
+// @Success 200 {object} Response
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 504 {object} ErrorResponse
+// @Param metric path string true "Metric name: dpm, kdr, hpm, dtm, assists, kad, ubers, drops, uber_build_time, airshots or captures"
+// @Param class query string false "Player class"
+// @Param mingames query int false "Minimum games played"
+// @Param period query string false "Shortcut time window: 7d, 30d or season"
+// @Param from query string false "Only count games played since this date (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)"
+// @Param limit query int false "Page size, all players by default"
+// @Param offset query int false "Number of players to skip"
+// @Param site query string false "Only rate players of this tf2pickup site, e.g. ru"
+// @Router /ratings/{metric} [get]
+func (h *Handler) Rating(ctx echo.Context) error {
+	return h.rating(ctx, ctx.Param("metric"))
 }
 
 // AverageDPM godoc
@@ -49,28 +151,21 @@ func NewHandler(e *echo.Echo, mongo *db.Client) {
 // @Accept */*
 // @Produce json
 // @Success 200 {object} Response
+// @Success 304 "Not Modified"
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Param class path string false "Player class"
-// @Param mingames path int false "Minimum games played"
+// @Failure 504 {object} ErrorResponse
+// @Param class query string false "Player class"
+// @Param mingames query int false "Minimum games played"
+// @Param period query string false "Shortcut time window: 7d, 30d or season"
+// @Param from query string false "Only count games played since this date (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)"
+// @Param limit query int false "Page size, all players by default"
+// @Param offset query int false "Number of players to skip"
+// @Param site query string false "Only rate players of this tf2pickup site, e.g. ru"
 // @Router /dpm [get]
 func (h *Handler) AverageDPM(ctx echo.Context) error {
-	class := ctx.QueryParam("class")
-	minGamesRaw := ctx.QueryParam("mingames")
-
-	minGames, err := parseMinGames(minGamesRaw)
-	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	}
-	if err := validateClass(class); err != nil {
-		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-	}
-
-	results, err := h.mongo.GetAverageDPM(class, minGames)
-	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
-	}
-	return ctx.JSON(http.StatusOK, Response{Stats: results})
+	return h.rating(ctx, "dpm")
 }
 
 // AverageKDR godoc
@@ -79,37 +174,21 @@ func (h *Handler) AverageDPM(ctx echo.Context) error {
 // @Accept */*
 // @Produce json
 // @Success 200 {object} Response
+// @Success 304 "Not Modified"
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Param class path string false "Player class"
-// @Param mingames path int false "Minimum games played"
+// @Failure 504 {object} ErrorResponse
+// @Param class query string false "Player class"
+// @Param mingames query int false "Minimum games played"
+// @Param period query string false "Shortcut time window: 7d, 30d or season"
+// @Param from query string false "Only count games played since this date (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)"
+// @Param limit query int false "Page size, all players by default"
+// @Param offset query int false "Number of players to skip"
+// @Param site query string false "Only rate players of this tf2pickup site, e.g. ru"
 // @Router /kdr [get]
 func (h *Handler) AverageKDR(ctx echo.Context) error {
-	class := ctx.QueryParam("class")
-	minGamesRaw := ctx.QueryParam("mingames")
-
-	minGames, err := parseMinGames(minGamesRaw)
-	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
-			"error": err.Error(),
-		})
-	}
-
-	if err := validateClass(class); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
-			"error": err.Error(),
-		})
-	}
-
-	results, err := h.mongo.GetAverageKDR(class, minGames)
-	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
-	}
-	return ctx.JSON(http.StatusOK, map[string][]db.Result{
-		"stats": results,
-	})
+	return h.rating(ctx, "kdr")
 }
 
 // AverageHealPerMin godoc
@@ -118,29 +197,92 @@ func (h *Handler) AverageKDR(ctx echo.Context) error {
 // @Accept */*
 // @Produce json
 // @Success 200 {object} Response
+// @Success 304 "Not Modified"
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Param mingames path int false "Minimum games played"
+// @Failure 504 {object} ErrorResponse
+// @Param class query string false "Player class"
+// @Param mingames query int false "Minimum games played"
+// @Param period query string false "Shortcut time window: 7d, 30d or season"
+// @Param from query string false "Only count games played since this date (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "Only count games played until this date (YYYY-MM-DD inclusive, or RFC3339)"
+// @Param limit query int false "Page size, all players by default"
+// @Param offset query int false "Number of players to skip"
+// @Param site query string false "Only rate players of this tf2pickup site, e.g. ru"
 // @Router /hpm [get]
 func (h *Handler) AverageHealPerMin(ctx echo.Context) error {
+	return h.rating(ctx, "hpm")
+}
+
+func (h *Handler) rating(ctx echo.Context, metricName string) error {
+	metric, ok := db.LookupMetric(metricName)
+	if !ok {
+		return notFound(db.ErrUnknownMetric)
+	}
+
+	class := ctx.QueryParam("class")
 	minGamesRaw := ctx.QueryParam("mingames")
 
-	minGames, err := parseMinGames(minGamesRaw)
+	minGames, err := parseMinGames(minGamesRaw, h.minGames)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
-			"error": err.Error(),
-		})
+		return badRequest(CodeBadMinGames, err)
+	}
+	if err := validateClass(class); err != nil {
+		return badRequest(CodeBadClass, err)
+	}
+	if !metric.AllowsClass(class) {
+		return badRequest(CodeBadClass, fmt.Errorf("%w: %s only rates %s", ErrBadMetricClass, metric.Name, strings.Join(metric.Classes, ", ")))
+	}
+	window, err := parseTimeWindow(ctx, time.Now())
+	if err != nil {
+		return badRequest(CodeBadTimeWindow, err)
+	}
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return badRequest(CodeBadPage, err)
+	}
+	site := ctx.QueryParam("site")
+	if err := h.validateSite(site); err != nil {
+		return badRequest(CodeBadSite, err)
 	}
 
-	results, err := h.mongo.GetAverageHealsPerMin(minGames)
+	q := db.RatingQuery{
+		Metric:     metric.Name,
+		Class:      class,
+		MinGames:   minGames,
+		Window:     window,
+		ZeroPolicy: h.zeroPolicy,
+		Site:       site,
+	}
+	if v, ok := h.store.(db.Versioned); ok {
+		generation, modified := v.Version()
+		// the resolved window, not the query string, so period windows get new ETags as they move
+		key := fmt.Sprintf("%s|%d|%d", q.Key(), offset, limit)
+		if setValidators(ctx, key, generation, modified) {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+	}
+
+	results, err := h.store.GetRating(ctx.Request().Context(), q)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return err
 	}
-	return ctx.JSON(http.StatusOK, map[string][]db.Result{
-		"stats": results,
-	})
+	resp := paginate(results, offset, limit)
+	resp.Meta = RatingMeta{Metric: metric.Name, Site: site, ZeroDenominator: h.zeroPolicy}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// validateSite accepts an empty site or one of the configured sites.
+func (h *Handler) validateSite(site string) error {
+	if site == "" {
+		return nil
+	}
+	for _, s := range h.sites {
+		if s == site {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q: must be one of %s", ErrBadSite, site, strings.Join(h.sites, ", "))
 }
 
 // GamesCount godoc
@@ -150,29 +292,225 @@ func (h *Handler) AverageHealPerMin(ctx echo.Context) error {
 // @Produce json
 // @Success 200 {object} GamesCount
 // @Failure 500 {object} ErrorResponse
+// @Failure 504 {object} ErrorResponse
 // @Router /gamesCount [get]
 func (h *Handler) GamesCount(ctx echo.Context) error {
-	count, err := h.mongo.GetGamesCount()
+	count, err := h.store.GetGamesCount(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, GamesCount{Count: count / 12})
+}
+
+// PlayersStatus godoc
+// @Summary Size and age of the in-memory player names directory.
+// @Tags Util
+// @Accept */*
+// @Produce json
+// @Success 200 {object} db.DirectoryStatus
+// @Router /status/players [get]
+func (h *Handler) PlayersStatus(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, h.store.PlayersStatus())
+}
+
+// DataQuality godoc
+// @Summary Malformed documents met while serving queries, skipped in results.
+// @Tags Util
+// @Accept */*
+// @Produce json
+// @Success 200 {object} db.QualityReport
+// @Router /status/data-quality [get]
+func (h *Handler) DataQuality(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, h.store.DataQuality())
+}
+
+// ResolverStatus godoc
+// @Summary Time and outcome of the last player sync run by the server.
+// @Description Interval is zero and the sync never happened when the server doesn't sync players.
+// @Tags Util
+// @Accept */*
+// @Produce json
+// @Success 200 {object} resolver.Status
+// @Router /status/resolver [get]
+func (h *Handler) ResolverStatus(ctx echo.Context) error {
+	if h.resolver == nil {
+		return ctx.JSON(http.StatusOK, resolver.Status{})
+	}
+	return ctx.JSON(http.StatusOK, h.resolver.Status())
+}
+
+// SearchPlayers godoc
+// @Summary Find players by current or previous names.
+// @Description Case-insensitive prefix, substring and typo-tolerant matching, best matches first.
+// @Tags Players
+// @Accept */*
+// @Produce json
+// @Success 200 {object} SearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Param q query string true "Part of a name, at least 2 characters"
+// @Param limit query int false "Maximum number of players, 10 by default"
+// @Router /players/search [get]
+func (h *Handler) SearchPlayers(ctx echo.Context) error {
+	q := strings.TrimSpace(ctx.QueryParam("q"))
+	if len([]rune(q)) < minSearchLength {
+		return badRequest(CodeBadQuery, ErrBadQuery)
+	}
+	limit, err := parseLimit(ctx.QueryParam("limit"), defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		return badRequest(CodeBadPage, err)
+	}
+
+	players, err := h.store.SearchPlayers(ctx.Request().Context(), q, limit)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, SearchResponse{Players: players})
+}
+
+// PlayerProfile godoc
+// @Summary Player profile with stats broken down per class.
+// @Tags Players
+// @Accept */*
+// @Produce json
+// @Success 200 {object} db.Profile
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 504 {object} ErrorResponse
+// @Param steamid path string true "Player SteamID64, SteamID3 ([U:1:x], URL-encoded) or STEAM_X:Y:Z"
+// @Router /players/{steamid} [get]
+func (h *Handler) PlayerProfile(ctx echo.Context) error {
+	steamID, err := parseSteamID(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, GamesCount{Count: count / 12})
+		return badRequest(CodeBadSteamID, err)
 	}
-	return ctx.JSON(http.StatusOK, map[string]int64{
-		"count": count / 12,
-	})
+
+	profile, err := h.store.GetPlayerProfile(ctx.Request().Context(), steamID)
+	if errors.Is(err, db.ErrPlayerNotFound) {
+		return notFound(err)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, profile)
+}
+
+// PlayerGames godoc
+// @Summary Player match history, newest first.
+// @Tags Players
+// @Accept */*
+// @Produce json
+// @Success 200 {object} GamesPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 504 {object} ErrorResponse
+// @Param steamid path string true "Player SteamID64, SteamID3 ([U:1:x], URL-encoded) or STEAM_X:Y:Z"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size, 20 by default"
+// @Router /players/{steamid}/games [get]
+func (h *Handler) PlayerGames(ctx echo.Context) error {
+	steamID, err := parseSteamID(ctx)
+	if err != nil {
+		return badRequest(CodeBadSteamID, err)
+	}
+
+	limit, err := parseLimit(ctx.QueryParam("limit"), defaultGamesPageSize, maxGamesPageSize)
+	if err != nil {
+		return badRequest(CodeBadPage, err)
+	}
+
+	var after *db.GameCursor
+	if raw := ctx.QueryParam("cursor"); raw != "" {
+		cursor, err := db.ParseGameCursor(raw)
+		if err != nil {
+			return badRequest(CodeBadPage, err)
+		}
+		after = &cursor
+	}
+
+	games, next, err := h.store.GetPlayerGames(ctx.Request().Context(), steamID, after, limit)
+	if err != nil {
+		return err
+	}
+	page := GamesPage{Games: games}
+	if next != nil {
+		page.NextCursor = next.String()
+	}
+	return ctx.JSON(http.StatusOK, page)
 }
 
 func validateClass(class string) error {
-	switch class {
-	case "scout", "soldier", "demoman", "medic", "":
-		return nil
-	default:
+	if class != "" && !db.ValidClass(class) {
 		return ErrBadClass
 	}
+	return nil
 }
 
-func parseMinGames(games string) (int, error) {
+func parseMinGames(games string, def int) (int, error) {
 	if games == "" {
-		return defaultMinGamesAmount, nil
+		return def, nil
+	}
+	minGames, err := strconv.Atoi(games)
+	if err != nil || minGames < 0 {
+		return 0, ErrBadMinGames
+	}
+	return minGames, nil
+}
+
+func parseLimit(raw string, def, max int) (int, error) {
+	if raw == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("%w: must be between 1 and %d", ErrBadLimit, max)
 	}
-	return strconv.Atoi(games)
+	return limit, nil
+}
+
+// parseSteamID reads the steamid path parameter in any format as SteamID64.
+func parseSteamID(ctx echo.Context) (string, error) {
+	raw, err := url.PathUnescape(ctx.Param("steamid"))
+	if err != nil {
+		return "", steamid.ErrInvalid
+	}
+	id, err := steamid.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// parsePage reads offset and limit of a rating page. Zero limit means the whole rating.
+func parsePage(ctx echo.Context) (offset, limit int, err error) {
+	if raw := ctx.QueryParam("offset"); raw != "" {
+		offset, err = strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return 0, 0, ErrBadOffset
+		}
+	}
+	limit, err = parseLimit(ctx.QueryParam("limit"), 0, maxRatingPageSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// paginate cuts a page out of the full rating, keeping the total size.
+func paginate(results []db.Result, offset, limit int) Response {
+	total := len(results)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return Response{Stats: results[offset:end], Total: total}
+}
+
+// parseTimeWindow reads either the period shortcut or explicit from/to bounds.
+func parseTimeWindow(ctx echo.Context, now time.Time) (db.TimeWindow, error) {
+	return db.ParseTimeWindow(ctx.QueryParam("period"), ctx.QueryParam("from"), ctx.QueryParam("to"), now)
 }
@@ -0,0 +1,493 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"PickupStats/pkg/db"
+	"PickupStats/pkg/resolver"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	scoutA   = "76561198000000001"
+	soldierB = "76561198000000002"
+	medicC   = "76561198000000003"
+	scoutD   = "76561198000000004"
+	nobody   = "76561198000000009"
+)
+
+func testGames() []db.Game {
+	start := time.Date(2021, 10, 1, 20, 0, 0, 0, time.UTC)
+	var games []db.Game
+	add := func(steamID, class string, stats db.GameStats) {
+		games = append(games, db.Game{
+			ID:     primitive.NewObjectID(),
+			LogID:  int64(3000000 + len(games)),
+			Date:   start.AddDate(0, 0, len(games)),
+			Length: 600,
+			Player: db.GamePlayer{SteamID: steamID, Class: class},
+			Stats:  stats,
+		})
+	}
+	for i := 0; i < 3; i++ {
+		add(scoutA, "scout", db.GameStats{DamageDone: 3000, Kills: 10, Deaths: 5})
+	}
+	for i := 0; i < 2; i++ {
+		add(soldierB, "soldier", db.GameStats{DamageDone: 2400, Kills: 6, Deaths: 6, Airshots: 2})
+	}
+	for i := 0; i < 2; i++ {
+		add(medicC, "medic", db.GameStats{Healed: 12000, Deaths: 2, Ubers: 3})
+	}
+	add(scoutD, "scout", db.GameStats{DamageDone: 3000, Kills: 4, Deaths: 2})
+	return games
+}
+
+func newTestServer(store db.StatsStore) *echo.Echo {
+	return newTestServerWithOptions(store, Options{MinGames: 10, Sites: []string{"ru", "eu"}})
+}
+
+func newTestServerWithOptions(store db.StatsStore, opts Options) *echo.Echo {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	e.HTTPErrorHandler = NewErrorHandler(e, log)
+	NewHandler(e, store, opts)
+	NewHealthHandler(e, store)
+	return e
+}
+
+func newTestStore() db.StatsStore {
+	return db.NewMemoryStore(testGames(), map[string]db.Player{
+		scoutA:   {Name: "A", Avatar: "a.jpg", Sites: []string{"ru"}},
+		soldierB: {Name: "B", Avatar: "b.jpg", Sites: []string{"ru", "eu"}, Aliases: []string{"Old B"}},
+		medicC:   {Name: "C", Avatar: "c.jpg"},
+	})
+}
+
+func rating(t *testing.T, body []byte) Response {
+	var r Response
+	if err := json.Unmarshal(body, &r); err != nil {
+		t.Fatalf("failed to decode rating: %v", err)
+	}
+	return r
+}
+
+func wantRating(total int, steamIDs []string, ranks []int) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		r := rating(t, body)
+		if r.Total != total {
+			t.Errorf("got total %d, want %d", r.Total, total)
+		}
+		if len(r.Stats) != len(steamIDs) {
+			t.Fatalf("got %d results, want %d", len(r.Stats), len(steamIDs))
+		}
+		for i, res := range r.Stats {
+			if res.SteamID64 != steamIDs[i] || res.Rank != ranks[i] {
+				t.Errorf("result %d: got %s rank %d, want %s rank %d", i, res.SteamID64, res.Rank, steamIDs[i], ranks[i])
+			}
+		}
+	}
+}
+
+func wantProfile(steamID string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var p db.Profile
+		if err := json.Unmarshal(body, &p); err != nil || p.SteamID64 != steamID {
+			t.Errorf("got %s, want profile of %s", body, steamID)
+		}
+	}
+}
+
+func wantError(code ErrorCode) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var r ErrorResponse
+		if err := json.Unmarshal(body, &r); err != nil {
+			t.Fatalf("failed to decode error: %v", err)
+		}
+		if r.Code != code || r.Error == "" || r.RequestID == "" {
+			t.Errorf("got %s, want code %s with a message and request id", body, code)
+		}
+	}
+}
+
+func wantPlayers(steamIDs ...string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var r SearchResponse
+		if err := json.Unmarshal(body, &r); err != nil {
+			t.Fatalf("failed to decode search: %v", err)
+		}
+		if len(r.Players) != len(steamIDs) {
+			t.Fatalf("got %d players, want %d", len(r.Players), len(steamIDs))
+		}
+		for i, p := range r.Players {
+			if p.SteamID64 != steamIDs[i] || p.Name == "" {
+				t.Errorf("player %d: got %+v, want %s", i, p, steamIDs[i])
+			}
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		{"dpm default min games", "/api/dpm", http.StatusOK, wantRating(0, nil, nil)},
+		{"dpm", "/api/dpm?mingames=0", http.StatusOK, wantRating(3, []string{scoutA, scoutD, soldierB}, []int{1, 1, 3})},
+		{"dpm page", "/api/dpm?mingames=0&limit=1&offset=1", http.StatusOK, wantRating(3, []string{scoutD}, []int{1})},
+		{"dpm offset past end", "/api/dpm?mingames=0&offset=10", http.StatusOK, wantRating(3, nil, nil)},
+		{"dpm by class", "/api/dpm?mingames=1&class=scout", http.StatusOK, wantRating(1, []string{scoutA}, []int{1})},
+		{"dpm from", "/api/dpm?mingames=0&from=2021-10-04", http.StatusOK, wantRating(2, []string{scoutD, soldierB}, []int{1, 2})},
+		{"dpm to", "/api/dpm?mingames=0&to=2021-10-02", http.StatusOK, wantRating(1, []string{scoutA}, []int{1})},
+		{"dpm future", "/api/dpm?mingames=0&from=2100-01-01T00:00:00Z", http.StatusOK, wantRating(0, nil, nil)},
+		{"dpm last week", "/api/dpm?mingames=0&period=7d", http.StatusOK, wantRating(0, nil, nil)},
+		{"dpm by site", "/api/dpm?mingames=0&site=ru", http.StatusOK, wantRating(2, []string{scoutA, soldierB}, []int{1, 2})},
+		{"dpm by other site", "/api/dpm?mingames=0&site=eu", http.StatusOK, wantRating(1, []string{soldierB}, []int{1})},
+		{"kdr", "/api/kdr?mingames=0", http.StatusOK, wantRating(3, []string{scoutA, scoutD, soldierB}, []int{1, 1, 3})},
+		{"hpm", "/api/hpm?mingames=0", http.StatusOK, wantRating(1, []string{medicC}, []int{1})},
+		{"hpm for medic", "/api/hpm?mingames=0&class=medic", http.StatusOK, wantRating(1, []string{medicC}, []int{1})},
+		{"airshots", "/api/ratings/airshots?mingames=0", http.StatusOK, wantRating(1, []string{soldierB}, []int{1})},
+		{"ubers", "/api/ratings/ubers?mingames=0", http.StatusOK, wantRating(1, []string{medicC}, []int{1})},
+		{"unknown metric", "/api/ratings/fun", http.StatusNotFound, wantError(CodeNotFound)},
+		{"metric class", "/api/ratings/ubers?class=scout", http.StatusBadRequest, wantError(CodeBadClass)},
+		{"bad class", "/api/dpm?class=pyro", http.StatusBadRequest, wantError(CodeBadClass)},
+		{"bad min games", "/api/kdr?mingames=ten", http.StatusBadRequest, wantError(CodeBadMinGames)},
+		{"bad period", "/api/dpm?period=1y", http.StatusBadRequest, wantError(CodeBadTimeWindow)},
+		{"period with from", "/api/dpm?period=7d&from=2021-10-01", http.StatusBadRequest, nil},
+		{"bad date", "/api/dpm?from=2021-13-01", http.StatusBadRequest, nil},
+		{"from after to", "/api/dpm?from=2021-10-05&to=2021-10-01", http.StatusBadRequest, nil},
+		{"bad limit", "/api/dpm?limit=0", http.StatusBadRequest, wantError(CodeBadPage)},
+		{"bad offset", "/api/dpm?offset=-1", http.StatusBadRequest, wantError(CodeBadPage)},
+		{"unknown site", "/api/dpm?site=us", http.StatusBadRequest, wantError(CodeBadSite)},
+		{"negative min games", "/api/kdr?mingames=-1", http.StatusBadRequest, wantError(CodeBadMinGames)},
+		{"unknown route", "/api/nope", http.StatusNotFound, wantError(CodeNotFound)},
+		{"games count", "/api/gamesCount", http.StatusOK, func(t *testing.T, body []byte) {
+			var c GamesCount
+			if err := json.Unmarshal(body, &c); err != nil || c.Count != 0 {
+				t.Errorf("got %s, want zero count", body)
+			}
+		}},
+		{"players status", "/api/status/players", http.StatusOK, func(t *testing.T, body []byte) {
+			var s db.DirectoryStatus
+			if err := json.Unmarshal(body, &s); err != nil || s.Size != 3 {
+				t.Errorf("got %s, want 3 players", body)
+			}
+		}},
+		{"data quality", "/api/status/data-quality", http.StatusOK, func(t *testing.T, body []byte) {
+			var r db.QualityReport
+			if err := json.Unmarshal(body, &r); err != nil || r.Issues == nil || r.Total != 0 {
+				t.Errorf("got %s, want an empty report", body)
+			}
+		}},
+		{"resolver disabled", "/api/status/resolver", http.StatusOK, func(t *testing.T, body []byte) {
+			var s resolver.Status
+			if err := json.Unmarshal(body, &s); err != nil || s.IntervalSeconds != 0 || !s.LastSync.IsZero() {
+				t.Errorf("got %s, want no syncs", body)
+			}
+		}},
+		{"profile", "/api/players/" + scoutA, http.StatusOK, func(t *testing.T, body []byte) {
+			var p db.Profile
+			if err := json.Unmarshal(body, &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.PlayerName != "A" || p.Games != 3 || p.Playtime != 1800 || len(p.Classes) != 1 {
+				t.Errorf("unexpected profile %s", body)
+			}
+			if p.Classes[0].DPM == nil || *p.Classes[0].DPM != 300 {
+				t.Errorf("got class stats %+v, want 300 dpm", p.Classes[0])
+			}
+		}},
+		{"search", "/api/players/search?q=old", http.StatusOK, wantPlayers(soldierB)},
+		{"search none", "/api/players/search?q=nobody", http.StatusOK, wantPlayers()},
+		{"search short", "/api/players/search?q=b", http.StatusBadRequest, wantError(CodeBadQuery)},
+		{"search bad limit", "/api/players/search?q=old&limit=0", http.StatusBadRequest, wantError(CodeBadPage)},
+		{"profile by steamid3", "/api/players/%5BU:1:39734273%5D", http.StatusOK, wantProfile(scoutA)},
+		{"profile by legacy steamid", "/api/players/STEAM_0:1:19867136", http.StatusOK, wantProfile(scoutA)},
+		{"rating steamid3", "/api/dpm?mingames=0&limit=1", http.StatusOK, func(t *testing.T, body []byte) {
+			if r := rating(t, body); len(r.Stats) != 1 || r.Stats[0].SteamID3 != "[U:1:39734273]" {
+				t.Errorf("got %s, want %s as [U:1:39734273]", body, scoutA)
+			}
+		}},
+		{"profile not found", "/api/players/" + nobody, http.StatusNotFound, wantError(CodeNotFound)},
+		{"profile bad steamid", "/api/players/abc", http.StatusBadRequest, wantError(CodeBadSteamID)},
+		{"games", "/api/players/" + scoutA + "/games?limit=2", http.StatusOK, func(t *testing.T, body []byte) {
+			var p GamesPage
+			if err := json.Unmarshal(body, &p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Games) != 2 || p.NextCursor == "" {
+				t.Errorf("got %d games and cursor %q, want 2 games and a cursor", len(p.Games), p.NextCursor)
+			}
+		}},
+		{"games bad cursor", "/api/players/" + scoutA + "/games?cursor=nope", http.StatusBadRequest, wantError(CodeBadPage)},
+		{"games bad limit", "/api/players/" + scoutA + "/games?limit=101", http.StatusBadRequest, nil},
+		{"games bad steamid", "/api/players/abc/games", http.StatusBadRequest, nil},
+	}
+
+	e := newTestServer(newTestStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.check != nil {
+				tt.check(t, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestPlayerGamesPagination(t *testing.T) {
+	e := newTestServer(newTestStore())
+
+	var dates []time.Time
+	target := "/api/players/" + scoutA + "/games?limit=2"
+	for target != "" {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+		var p GamesPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range p.Games {
+			dates = append(dates, g.Date)
+		}
+		target = ""
+		if p.NextCursor != "" {
+			target = "/api/players/" + scoutA + "/games?limit=2&cursor=" + p.NextCursor
+		}
+	}
+
+	if len(dates) != 3 {
+		t.Fatalf("got %d games, want 3", len(dates))
+	}
+	for i := 1; i < len(dates); i++ {
+		if !dates[i].Before(dates[i-1]) {
+			t.Errorf("games are not sorted newest first: %v", dates)
+		}
+	}
+}
+
+func TestRatingNotModified(t *testing.T) {
+	cache := db.NewRatingCache(newTestStore(), 0)
+	e := newTestServer(cache)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/dpm?mingames=0", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and etag %q", rec.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/dpm?mingames=0", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", rec.Code)
+	}
+
+	cache.Invalidate()
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d after invalidation, want 200", rec.Code)
+	}
+}
+
+func TestRatingETagFollowsWindow(t *testing.T) {
+	e := newTestServer(db.NewRatingCache(newTestStore(), 0))
+	etag := func(target string) string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Header().Get("ETag")
+	}
+
+	if a, b := etag("/api/dpm?from=2021-06-01"), etag("/api/dpm?from=2021-06-01T00:00:00Z"); a != b {
+		t.Errorf("got etags %s and %s for the same window, want equal", a, b)
+	}
+	if a, b := etag("/api/dpm?from=2021-06-01"), etag("/api/dpm?from=2021-06-02"); a == b {
+		t.Errorf("got etag %s for different windows, want them to differ", a)
+	}
+	if a, b := etag("/api/dpm?limit=10"), etag("/api/dpm?limit=10&offset=10"); a == b {
+		t.Errorf("got etag %s for different pages, want them to differ", a)
+	}
+}
+
+type unreachableStore struct {
+	db.StatsStore
+}
+
+func (unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestReadyzUnavailable(t *testing.T) {
+	e := newTestServer(unreachableStore{newTestStore()})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want 503", rec.Code)
+	}
+	var r Readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Checks["mongo"] != "server selection timeout" || r.Checks["players"] != "ok" {
+		t.Errorf("unexpected checks %v", r.Checks)
+	}
+}
+
+type timeoutStore struct {
+	db.StatsStore
+}
+
+func (timeoutStore) GetRating(ctx context.Context, q db.RatingQuery) ([]db.Result, error) {
+	return nil, fmt.Errorf("%w: context deadline exceeded", db.ErrQueryTimeout)
+}
+
+func TestRatingTimeout(t *testing.T) {
+	e := newTestServer(timeoutStore{newTestStore()})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/dpm", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d, want 504", rec.Code)
+	}
+	wantError(CodeUpstreamTimeout)(t, rec.Body.Bytes())
+}
+
+type brokenStore struct {
+	db.StatsStore
+}
+
+func (brokenStore) GetPlayerProfile(ctx context.Context, steamID string) (*db.Profile, error) {
+	return nil, errors.New("connection(db:27017[-3]) incomplete read of message header")
+}
+
+func TestInternalErrorHidden(t *testing.T) {
+	e := newTestServer(brokenStore{newTestStore()})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/players/"+scoutA, nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", rec.Code)
+	}
+	wantError(CodeInternal)(t, rec.Body.Bytes())
+
+	var r ErrorResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &r)
+	if r.Error != "internal server error" {
+		t.Errorf("got message %q, internal details leaked", r.Error)
+	}
+	if r.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Errorf("request id %q doesn't match header %q", r.RequestID, rec.Header().Get(echo.HeaderXRequestID))
+	}
+}
+
+func TestRatingZeroDenominator(t *testing.T) {
+	games := append(testGames(), db.Game{
+		ID:     primitive.NewObjectID(),
+		LogID:  3100000,
+		Date:   time.Date(2021, 10, 20, 20, 0, 0, 0, time.UTC),
+		Length: 600,
+		Player: db.GamePlayer{SteamID: nobody, Class: "scout"},
+		Stats:  db.GameStats{DamageDone: 1000, Kills: 30},
+	})
+	store := db.NewMemoryStore(games, nil)
+
+	tests := []struct {
+		policy db.ZeroPolicy
+		total  int
+	}{
+		{"", 3},
+		{db.ZeroSkip, 3},
+		{db.ZeroAsOne, 4},
+		{db.ZeroNull, 4},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			e := newTestServerWithOptions(store, Options{ZeroPolicy: tt.policy})
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/kdr?mingames=0", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			r := rating(t, rec.Body.Bytes())
+			if r.Total != tt.total {
+				t.Fatalf("got %d players, want %d", r.Total, tt.total)
+			}
+			want := tt.policy
+			if want == "" {
+				want = db.ZeroSkip
+			}
+			if r.Meta.ZeroDenominator != want || r.Meta.Metric != "kdr" {
+				t.Errorf("got meta %+v, want %s policy", r.Meta, want)
+			}
+
+			switch tt.policy {
+			case db.ZeroAsOne:
+				if first := r.Stats[0]; first.SteamID64 != nobody || first.Value == nil || *first.Value != 30 {
+					t.Errorf("got first %+v, want %s with kills as KDR", first, nobody)
+				}
+			case db.ZeroNull:
+				if last := r.Stats[3]; last.SteamID64 != nobody || last.Value != nil || last.Rank != 4 {
+					t.Errorf("got last %+v, want %s without value", last, nobody)
+				}
+			}
+		})
+	}
+}
+
+type syncStatus resolver.Status
+
+func (s syncStatus) Status() resolver.Status {
+	return resolver.Status(s)
+}
+
+func TestResolverStatus(t *testing.T) {
+	synced := time.Date(2021, 10, 20, 12, 0, 0, 0, time.UTC)
+	e := newTestServerWithOptions(newTestStore(), Options{Resolver: syncStatus{
+		IntervalSeconds: 3600,
+		LastSync:        synced,
+		LastSuccess:     synced,
+		Summary:         &resolver.Summary{Inserted: 2, Unchanged: 10},
+	}})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status/resolver", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	var s resolver.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastSync.Equal(synced) || s.IntervalSeconds != 3600 || s.Summary == nil || s.Summary.Inserted != 2 {
+		t.Errorf("got %s", rec.Body)
+	}
+}
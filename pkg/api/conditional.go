@@ -0,0 +1,45 @@
+package api
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// setValidators sets ETag and Last-Modified of a rating response and reports
+// whether the client already has it, in which case 304 should be sent. The ETag
+// is derived from key, which must identify the response within a cache generation.
+func setValidators(ctx echo.Context, key string, generation uint64, modified time.Time) bool {
+	req := ctx.Request()
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d|%s", generation, key)))
+	etag := fmt.Sprintf(`"%x"`, sum[:8])
+	modified = modified.UTC().Truncate(time.Second)
+
+	header := ctx.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modified.Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-cache")
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.After(t)
+	}
+	return false
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"PickupStats/pkg/db"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorCode tells clients what went wrong without parsing messages.
+type ErrorCode string
+
+const (
+	CodeBadClass        ErrorCode = "bad_class"
+	CodeBadMinGames     ErrorCode = "bad_min_games"
+	CodeBadSteamID      ErrorCode = "bad_steamid"
+	CodeBadTimeWindow   ErrorCode = "bad_time_window"
+	CodeBadPage         ErrorCode = "bad_page"
+	CodeBadSite         ErrorCode = "bad_site"
+	CodeBadQuery        ErrorCode = "bad_query"
+	CodeBadRequest      ErrorCode = "bad_request"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeUpstreamTimeout ErrorCode = "upstream_timeout"
+	CodeInternal        ErrorCode = "internal"
+)
+
+type ErrorResponse struct {
+	Code  ErrorCode `json:"code" enums:"bad_class,bad_min_games,bad_steamid,bad_time_window,bad_page,bad_site,bad_query,bad_request,not_found,upstream_timeout,internal"`
+	Error string    `json:"error"`
+	// RequestID matches the X-Request-ID header and server logs.
+	RequestID string `json:"request_id"`
+}
+
+// Error is returned by handlers for errors the client can act on.
+// Other errors are answered by the error handler as internal.
+type Error struct {
+	Status int
+	Code   ErrorCode
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(code ErrorCode, err error) error {
+	return &Error{Status: http.StatusBadRequest, Code: code, Err: err}
+}
+
+func notFound(err error) error {
+	return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Err: err}
+}
+
+// NewErrorHandler answers errors under /api with an ErrorResponse. Messages of
+// internal errors are logged with the request ID and hidden from clients.
+// Errors of other routes, e.g. pages, go to echo's default handler.
+func NewErrorHandler(e *echo.Echo, log logrus.FieldLogger) echo.HTTPErrorHandler {
+	return func(err error, ctx echo.Context) {
+		if ctx.Response().Committed {
+			return
+		}
+		if !strings.HasPrefix(ctx.Request().URL.Path, "/api/") {
+			e.DefaultHTTPErrorHandler(err, ctx)
+			return
+		}
+
+		// validators may be set before the query fails, errors must not be cached under them
+		ctx.Response().Header().Del("ETag")
+		ctx.Response().Header().Del("Last-Modified")
+
+		requestID := ctx.Response().Header().Get(echo.HeaderXRequestID)
+		resp, status := errorResponse(err)
+		resp.RequestID = requestID
+		switch status {
+		case http.StatusInternalServerError:
+			log.WithField("request_id", requestID).Errorf("%s %s: %v", ctx.Request().Method, ctx.Request().URL, err)
+		case http.StatusGatewayTimeout:
+			log.WithField("request_id", requestID).Warnf("%s %s: %v", ctx.Request().Method, ctx.Request().URL, err)
+		}
+
+		if ctx.Request().Method == http.MethodHead {
+			err = ctx.NoContent(status)
+		} else {
+			err = ctx.JSON(status, resp)
+		}
+		if err != nil {
+			log.Errorf("Failed to send error response: %v", err)
+		}
+	}
+}
+
+func errorResponse(err error) (ErrorResponse, int) {
+	var apiErr *Error
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+		return ErrorResponse{Code: apiErr.Code, Error: apiErr.Error()}, apiErr.Status
+	case errors.Is(err, db.ErrQueryTimeout):
+		return ErrorResponse{Code: CodeUpstreamTimeout, Error: db.ErrQueryTimeout.Error()}, http.StatusGatewayTimeout
+	case errors.As(err, &httpErr) && httpErr.Code < http.StatusInternalServerError:
+		code := CodeBadRequest
+		if httpErr.Code == http.StatusNotFound {
+			code = CodeNotFound
+		}
+		return ErrorResponse{Code: code, Error: fmt.Sprint(httpErr.Message)}, httpErr.Code
+	default:
+		return ErrorResponse{Code: CodeInternal, Error: "internal server error"}, http.StatusInternalServerError
+	}
+}
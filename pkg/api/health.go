@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"PickupStats/pkg/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+const pingTimeout = 2 * time.Second
+
+// Readiness lists results of readiness checks, "ok" or the reason a check failed.
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewHealthHandler registers /healthz and /readyz for the orchestrator.
+func NewHealthHandler(e *echo.Echo, store db.StatsStore) {
+	h := &Handler{store: store}
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
+}
+
+// Healthz answers as long as the process serves requests.
+func (h *Handler) Healthz(ctx echo.Context) error {
+	return ctx.String(http.StatusOK, "ok")
+}
+
+// Readyz reports whether MongoDB is reachable and player names are loaded.
+func (h *Handler) Readyz(ctx echo.Context) error {
+	r := Readiness{Status: "ok", Checks: map[string]string{"mongo": "ok", "players": "ok"}}
+
+	pingCtx, cancel := context.WithTimeout(ctx.Request().Context(), pingTimeout)
+	defer cancel()
+	if err := h.store.Ping(pingCtx); err != nil {
+		r.Checks["mongo"] = err.Error()
+		r.Status = "unavailable"
+	}
+	if h.store.PlayersStatus().LoadedAt.IsZero() {
+		r.Checks["players"] = "names are not loaded yet"
+		r.Status = "unavailable"
+	}
+
+	if r.Status != "ok" {
+		return ctx.JSON(http.StatusServiceUnavailable, r)
+	}
+	return ctx.JSON(http.StatusOK, r)
+}
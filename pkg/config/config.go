@@ -1,26 +1,275 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
+// EnvPrefix starts names of environment variables overriding config values.
+const EnvPrefix = "PICKUPSTATS_"
+
 type Config struct {
 	DSN            string `yaml:"dsn"`
 	Database       string `yaml:"database"`
 	GameCollection string `yaml:"gameCollection"`
 	NameCollection string `yaml:"nameCollection"`
+	// SummaryCollection is optional, see summarizer.
+	SummaryCollection string `yaml:"summaryCollection"`
+
+	Server ServerConfig `yaml:"server"`
+	Log    LogConfig    `yaml:"log"`
+	Cache  CacheConfig  `yaml:"cache"`
+	// Resolver tunes syncing players of Sites, see pkg/resolver.
+	Resolver ResolverConfig `yaml:"resolver"`
+	// MinGames is the default minimum of games a player needs to appear in ratings.
+	MinGames int `yaml:"minGames"`
+	// QueryTimeout bounds every MongoDB query, zero disables it.
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
+	// Sites are tf2pickup instances players are resolved from.
+	Sites []Site `yaml:"sites"`
+	// ZeroDenominator decides what ratings do with players whose denominator is zero,
+	// e.g. KDR of a player who never died: skip them, divide by one, or report null.
+	ZeroDenominator string `yaml:"zeroDenominator"`
+}
+
+type ServerConfig struct {
+	Address      string        `yaml:"address"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// ShutdownTimeout is how long in-flight requests are drained on SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// CORSOrigins allowed to call the API, any origin when empty.
+	CORSOrigins []string `yaml:"corsOrigins"`
+}
+
+// Site is a tf2pickup instance, e.g. ru with URL https://api.tf2pickup.ru.
+type Site struct {
+	// Name tags players resolved from the site and filters ratings by site.
+	Name string `yaml:"name"`
+	// URL is the site API root, players are read from URL/players.
+	URL string `yaml:"url"`
+}
+
+// SiteNames lists names of configured sites.
+func (c *Config) SiteNames() []string {
+	names := make([]string, len(c.Sites))
+	for i, s := range c.Sites {
+		names[i] = s.Name
+	}
+	return names
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+	// Format is either text or json.
+	Format string `yaml:"format"`
 }
 
+type CacheConfig struct {
+	// PlayersRefresh is how often player names are reloaded.
+	PlayersRefresh time.Duration `yaml:"playersRefresh"`
+	// GamesCheck is how often cached ratings are checked for new games.
+	GamesCheck time.Duration `yaml:"gamesCheck"`
+	// RatingsTTL limits how long a rating is cached, forever when zero.
+	RatingsTTL time.Duration `yaml:"ratingsTTL"`
+}
+
+type ResolverConfig struct {
+	// Interval is how often the server syncs players. Zero, the default, leaves it to playerResolver.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds every request to a site.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries of a failed request, waiting Backoff before the first one and twice as long before every next.
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// Default returns the config used for values missing from the file and environment.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Address:         ":1323",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Cache: CacheConfig{
+			PlayersRefresh: 10 * time.Minute,
+			GamesCheck:     time.Minute,
+		},
+		Resolver: ResolverConfig{
+			Timeout: 30 * time.Second,
+			Retries: 3,
+			Backoff: 2 * time.Second,
+		},
+		MinGames:        10,
+		QueryTimeout:    10 * time.Second,
+		ZeroDenominator: "skip",
+		Sites:           []Site{{Name: "ru", URL: "http://api.tf2pickup.ru"}},
+	}
+}
+
+// LoadConfig reads the YAML file at path over the defaults and applies environment overrides.
+// Empty path skips the file, so the config can come from the environment alone.
+// The result is not validated, call Validate once all overrides are applied.
 func LoadConfig(path string) (*Config, error) {
-	var config *Config
-	yamlFile, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	config := Default()
+	if path != "" {
+		yamlFile, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(yamlFile, config); err != nil {
+			return nil, err
+		}
 	}
-	if err = yaml.Unmarshal(yamlFile, &config); err != nil {
+	if err := config.applyEnv(os.LookupEnv); err != nil {
 		return nil, err
 	}
 	return config, nil
 }
+
+// envVars maps environment variable names, without EnvPrefix, onto config values.
+func (c *Config) envVars() map[string]interface{} {
+	return map[string]interface{}{
+		"DSN":                &c.DSN,
+		"DATABASE":           &c.Database,
+		"GAME_COLLECTION":    &c.GameCollection,
+		"NAME_COLLECTION":    &c.NameCollection,
+		"SUMMARY_COLLECTION": &c.SummaryCollection,
+		"ADDRESS":            &c.Server.Address,
+		"READ_TIMEOUT":       &c.Server.ReadTimeout,
+		"WRITE_TIMEOUT":      &c.Server.WriteTimeout,
+		"SHUTDOWN_TIMEOUT":   &c.Server.ShutdownTimeout,
+		"CORS_ORIGINS":       &c.Server.CORSOrigins,
+		"LOG_LEVEL":          &c.Log.Level,
+		"LOG_FORMAT":         &c.Log.Format,
+		"PLAYERS_REFRESH":    &c.Cache.PlayersRefresh,
+		"GAMES_CHECK":        &c.Cache.GamesCheck,
+		"RATINGS_TTL":        &c.Cache.RatingsTTL,
+		"RESOLVER_INTERVAL":  &c.Resolver.Interval,
+		"RESOLVER_TIMEOUT":   &c.Resolver.Timeout,
+		"RESOLVER_RETRIES":   &c.Resolver.Retries,
+		"RESOLVER_BACKOFF":   &c.Resolver.Backoff,
+		"MIN_GAMES":          &c.MinGames,
+		"QUERY_TIMEOUT":      &c.QueryTimeout,
+		"ZERO_DENOMINATOR":   &c.ZeroDenominator,
+		"SITES":              &c.Sites,
+	}
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for name, target := range c.envVars() {
+		raw, ok := lookup(EnvPrefix + name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch v := target.(type) {
+		case *string:
+			*v = raw
+		case *int:
+			*v, err = strconv.Atoi(raw)
+		case *time.Duration:
+			*v, err = time.ParseDuration(raw)
+		case *[]string:
+			*v = splitList(raw)
+		case *[]Site:
+			*v, err = parseSites(raw)
+		}
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", EnvPrefix, name, err)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSites reads sites written as name=url pairs separated by commas.
+func parseSites(raw string) ([]Site, error) {
+	var sites []Site
+	for _, item := range splitList(raw) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("site %q must be written as name=url", item)
+		}
+		sites = append(sites, Site{Name: strings.TrimSpace(parts[0]), URL: strings.TrimSpace(parts[1])})
+	}
+	return sites, nil
+}
+
+// Validate reports every missing or invalid value at once.
+func (c *Config) Validate() error {
+	var problems []string
+	required := []struct{ name, value string }{
+		{"dsn", c.DSN},
+		{"database", c.Database},
+		{"gameCollection", c.GameCollection},
+		{"nameCollection", c.NameCollection},
+		{"server.address", c.Server.Address},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			problems = append(problems, r.name+" is required")
+		}
+	}
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level: %v", err))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		problems = append(problems, fmt.Sprintf("log.format must be text or json, got %q", c.Log.Format))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.ShutdownTimeout < 0 || c.QueryTimeout < 0 || c.Cache.RatingsTTL < 0 ||
+		c.Resolver.Interval < 0 || c.Resolver.Timeout < 0 || c.Resolver.Backoff < 0 {
+		problems = append(problems, "timeouts and TTLs can't be negative")
+	}
+	if c.Cache.PlayersRefresh <= 0 || c.Cache.GamesCheck <= 0 {
+		problems = append(problems, "cache.playersRefresh and cache.gamesCheck must be positive")
+	}
+	switch c.ZeroDenominator {
+	case "skip", "one", "null":
+	default:
+		problems = append(problems, fmt.Sprintf("zeroDenominator must be skip, one or null, got %q", c.ZeroDenominator))
+	}
+	seen := make(map[string]bool)
+	for i, s := range c.Sites {
+		switch {
+		case s.Name == "" || s.URL == "":
+			problems = append(problems, fmt.Sprintf("sites[%d] needs both name and url", i))
+		case seen[s.Name]:
+			problems = append(problems, fmt.Sprintf("site %s is listed twice", s.Name))
+		}
+		seen[s.Name] = true
+	}
+	if c.Resolver.Retries < 0 {
+		problems = append(problems, "resolver.retries can't be negative")
+	}
+	if c.MinGames < 0 {
+		problems = append(problems, "minGames can't be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"PICKUPSTATS_DSN":               "mongodb://db",
+		"PICKUPSTATS_ADDRESS":           ":8080",
+		"PICKUPSTATS_READ_TIMEOUT":      "5s",
+		"PICKUPSTATS_CORS_ORIGINS":      "https://a.org, https://b.org,",
+		"PICKUPSTATS_MIN_GAMES":         "3",
+		"PICKUPSTATS_SITES":             "ru=https://api.tf2pickup.ru, eu=https://api.tf2pickup.eu",
+		"PICKUPSTATS_RESOLVER_INTERVAL": "1h",
+		"PICKUPSTATS_RESOLVER_RETRIES":  "5",
+	}
+	c := Default()
+	err := c.applyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DSN != "mongodb://db" || c.Server.Address != ":8080" || c.Server.ReadTimeout != 5*time.Second || c.MinGames != 3 {
+		t.Errorf("env not applied: %+v", c)
+	}
+	if len(c.Server.CORSOrigins) != 2 || c.Server.CORSOrigins[1] != "https://b.org" {
+		t.Errorf("got origins %q", c.Server.CORSOrigins)
+	}
+	if len(c.Sites) != 2 || c.Sites[1] != (Site{Name: "eu", URL: "https://api.tf2pickup.eu"}) {
+		t.Errorf("got sites %+v", c.Sites)
+	}
+	if c.Resolver.Interval != time.Hour || c.Resolver.Retries != 5 || c.Resolver.Backoff != 2*time.Second {
+		t.Errorf("got resolver %+v", c.Resolver)
+	}
+	if c.Log.Level != "info" {
+		t.Errorf("got log level %q, want default kept", c.Log.Level)
+	}
+}
+
+func TestApplyEnvBadValue(t *testing.T) {
+	c := Default()
+	err := c.applyEnv(func(name string) (string, bool) {
+		return "soon", name == "PICKUPSTATS_GAMES_CHECK"
+	})
+	if err == nil || !strings.Contains(err.Error(), "PICKUPSTATS_GAMES_CHECK") {
+		t.Errorf("got %v, want error naming the variable", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Log.Format = "xml"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("empty config passed validation")
+	}
+	for _, want := range []string{"dsn is required", "database is required", "gameCollection is required", "nameCollection is required", "log.format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+
+	c = Default()
+	c.Sites = append(c.Sites, Site{Name: "ru", URL: "https://ru.example"}, Site{Name: "eu"})
+	c.Resolver.Retries = -1
+	err = c.Validate()
+	for _, want := range []string{"site ru is listed twice", "sites[2] needs both name and url", "resolver.retries"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v doesn't mention %q", err, want)
+		}
+	}
+
+	c = Default()
+	c.DSN, c.Database, c.GameCollection, c.NameCollection = "mongodb://db", "pickups", "games", "players"
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid config failed: %v", err)
+	}
+}
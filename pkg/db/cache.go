@@ -0,0 +1,164 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"PickupStats/pkg/monitoring"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxCacheEntries bounds the rating cache, whose keys include time windows and minimums
+// taken from requests. The least recently used rating is dropped beyond it.
+const maxCacheEntries = 1000
+
+// RatingCache keeps ratings of the wrapped store until new games arrive or player names change.
+// It is invalidated when the store's data version changes or the store reports changes itself.
+// Other queries are passed through.
+type RatingCache struct {
+	StatsStore
+
+	// ttl limits the age of cached ratings, in case a change is missed. Zero keeps them until invalidated.
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]*list.Element
+	// recent holds *cacheEntry values, the most recently used first.
+	recent     *list.List
+	generation uint64
+	modified   time.Time
+	version    string
+}
+
+type cacheEntry struct {
+	key      string
+	results  []Result
+	cachedAt time.Time
+}
+
+func NewRatingCache(store StatsStore, ttl time.Duration) *RatingCache {
+	return &RatingCache{
+		StatsStore: store,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+		modified:   time.Now(),
+	}
+}
+
+// Key identifies the rating q selects, with its time window resolved.
+func (q RatingQuery) Key() string {
+	return fmt.Sprintf("%s|%s|%d|%d|%d|%s|%s", q.Metric, q.Class, q.MinGames, unixOrZero(q.Window.From), unixOrZero(q.Window.To), q.ZeroPolicy, q.Site)
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// GetRating returns a cached rating, computing it on a miss.
+func (rc *RatingCache) GetRating(ctx context.Context, q RatingQuery) ([]Result, error) {
+	key := q.Key()
+
+	rc.mu.Lock()
+	var entry *cacheEntry
+	if el, ok := rc.entries[key]; ok {
+		rc.recent.MoveToFront(el)
+		entry = el.Value.(*cacheEntry)
+	}
+	generation := rc.generation
+	rc.mu.Unlock()
+	if entry != nil && (rc.ttl == 0 || time.Since(entry.cachedAt) < rc.ttl) {
+		monitoring.CacheRequests.WithLabelValues("hit").Inc()
+		return entry.results, nil
+	}
+	monitoring.CacheRequests.WithLabelValues("miss").Inc()
+
+	results, err := rc.StatsStore.GetRating(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	// don't store results computed before an invalidation
+	if generation == rc.generation {
+		rc.store(&cacheEntry{key: key, results: results, cachedAt: time.Now()})
+	}
+	rc.mu.Unlock()
+	return results, nil
+}
+
+// store adds or replaces an entry, dropping the least recently used one beyond maxCacheEntries.
+// rc.mu must be held.
+func (rc *RatingCache) store(entry *cacheEntry) {
+	if el, ok := rc.entries[entry.key]; ok {
+		el.Value = entry
+		rc.recent.MoveToFront(el)
+		return
+	}
+	rc.entries[entry.key] = rc.recent.PushFront(entry)
+	if rc.recent.Len() > maxCacheEntries {
+		oldest := rc.recent.Back()
+		rc.recent.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Version identifies the current cache contents: the generation changes and
+// modified moves forward on every invalidation.
+func (rc *RatingCache) Version() (generation uint64, modified time.Time) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.generation, rc.modified
+}
+
+func (rc *RatingCache) Invalidate() {
+	rc.mu.Lock()
+	rc.entries = make(map[string]*list.Element)
+	rc.recent.Init()
+	rc.generation++
+	rc.modified = time.Now()
+	rc.mu.Unlock()
+}
+
+// Run invalidates the cache when games change, checking the data version
+// every interval and listening to store notifications when available.
+func (rc *RatingCache) Run(ctx context.Context, interval time.Duration, log logrus.FieldLogger) {
+	changed := make(chan struct{}, 1)
+	if w, ok := rc.StatsStore.(changeWatcher); ok {
+		go w.watchChanges(ctx, changed, log)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rc.check(ctx, log)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			rc.Invalidate()
+		case <-ticker.C:
+			rc.check(ctx, log)
+		}
+	}
+}
+
+func (rc *RatingCache) check(ctx context.Context, log logrus.FieldLogger) {
+	version, err := rc.DataVersion(ctx)
+	if err != nil {
+		log.Errorf("Failed to get data version: %v", err)
+		return
+	}
+	if rc.version != "" && version != rc.version {
+		rc.Invalidate()
+	}
+	rc.version = version
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRatingCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	rc := NewRatingCache(NewMemoryStore(nil, nil), 0)
+	ctx := context.Background()
+	query := func(minGames int) RatingQuery {
+		return RatingQuery{Metric: "dpm", MinGames: minGames}
+	}
+
+	for i := 0; i < maxCacheEntries; i++ {
+		if _, err := rc.GetRating(ctx, query(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// touch the oldest entry so the second one is dropped instead
+	if _, err := rc.GetRating(ctx, query(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.GetRating(ctx, query(maxCacheEntries)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rc.entries) != maxCacheEntries || rc.recent.Len() != maxCacheEntries {
+		t.Errorf("got %d entries, want %d", len(rc.entries), maxCacheEntries)
+	}
+	if _, ok := rc.entries[query(0).Key()]; !ok {
+		t.Error("recently used entry was dropped")
+	}
+	if _, ok := rc.entries[query(1).Key()]; ok {
+		t.Error("least recently used entry was kept")
+	}
+}
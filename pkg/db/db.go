@@ -2,8 +2,14 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"PickupStats/pkg/monitoring"
+	"PickupStats/pkg/steamid"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,280 +20,255 @@ type User struct {
 	Name string `bson:"Name"`
 }
 
-const (
-	dpmAggregationTemplate = `
-	[
-		{
-			"$match": {"player.class": {"%s": "%s"}}
-		},
-		{
-			"$group": {
-				"_id":"$player.steam_id",
-				"sum_damage": {"$sum": "$stats.damage_done" },
-				"sum_playtime": {"$sum": "$length"},
-				"count_games": {"$sum": 1}
-			}
-		},
-		{
-			"$project": {
-				"dpm": {"$round": [{"$divide": ["$sum_damage", {"$divide": ["$sum_playtime", 60]}]}, 2]},
-				"games": "$count_games"
-			}
-		},
-		{"$sort": {"dpm": -1, "games": -1}},
-		{"$match": {"games": {"$gt": %d}}}
-	]`
-	kdrAggregationTemplate = `
-	[
-		{
-			"$match": {"player.class": {"%s": "%s"}}
-		},
-		{
-			"$group": {
-				"_id":"$player.steam_id",
-				"sum_kills": {"$sum": "$stats.kills" },
-				"sum_deaths": {"$sum": "$stats.deaths"},
-				"count_games": {"$sum": 1}
-			}
-		},
-		{
-			"$project": {
-				"kdr": {"$round": [ {"$divide": ["$sum_kills", "$sum_deaths"]}, 1]},
-				"games": "$count_games"
-			}
-		},
-		{"$sort": {"kdr": -1, "games": -1}},
-		{"$match": {"games": {"$gt": %d}}}
-	]`
-	healsPerMinAggregationTemplate = `
-	[
-		{
-			"$match": {"player.class": {"$eq": "medic"}}
-		},
-		{
-			"$group": {
-				"_id": "$player.steam_id",
-				"sum_heals": {"$sum": "$stats.healed"},
-				"sum_playtime": {"$sum": "$length"},
-				"count_games": {"$sum": 1}
-			}
-		},
-		{
-			"$project": {
-				"hpm": {"$round": [{"$divide": ["$sum_heals", {"$divide": ["$sum_playtime", 60]}]}, 2]},
-				"games": "$count_games"
-			}
-		},
-		{"$sort": {"hpm": -1, "games": -1}},
-		{"$match": {"games": {"$gt": %d}}}
-	]`
-)
+// ErrQueryTimeout is returned when a query runs longer than Client.QueryTimeout.
+var ErrQueryTimeout = errors.New("query timed out")
 
 type Client struct {
 	database, games, names string
-	ctx                    context.Context
+	summaries              string
 	Conn                   *mongo.Client
+	Players                *PlayerDirectory
+	quality                *qualityLog
+	// QueryTimeout bounds every query on top of the caller's context. Zero disables it.
+	QueryTimeout time.Duration
 }
 
 type Player struct {
 	Name   string `json:"Name"`
 	Avatar string `json:"Avatar"`
+	// Sites lists tf2pickup sites the player is on, see playerResolver.
+	Sites []string `json:"Sites"`
+	// Aliases are previous names from the player's history, used by SearchPlayers.
+	Aliases []string `json:"Aliases"`
 }
 
-type Result struct {
-	PlayerName string   `json:"player_name"`
-	Avatar     string   `json:"avatar"`
-	SteamID64  string   `json:"steamid64"`
-	DPM        *float64 `json:"dpm,omitempty"`
-	KDR        *float64 `json:"kdr,omitempty"`
-	HPM        *float64 `json:"hpm,omitempty"`
-	Games      int32    `json:"games"`
+// OnSite reports whether the player is on the site.
+func (p Player) OnSite(site string) bool {
+	return containsString(p.Sites, site)
 }
 
-func (r *Result) SetName(name string) {
-	r.PlayerName = name
-}
-
-func NewClient(ctx context.Context, dsn, database, gamesCollection, namesCollection string) (*Client, error) {
-	conn, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
-	if err != nil {
-		return nil, err
-	}
-	return &Client{
-		database: database,
-		games:    gamesCollection,
-		names:    namesCollection,
-		ctx:      ctx,
-		Conn:     conn,
-	}, nil
+// Result is a single rating row. Besides metric and value it is
+// marshalled with the metric name as a key ("dpm": 250.5) for older clients.
+// Value is nil for players with a zero denominator under ZeroNull.
+type Result struct {
+	Rank       int    `json:"rank"`
+	PlayerName string `json:"player_name"`
+	Avatar     string `json:"avatar"`
+	SteamID64  string `json:"steamid64"`
+	// SteamID3 is the [U:1:x] form shown by logs.tf and the game console.
+	SteamID3 string   `json:"steamid3"`
+	Metric   string   `json:"metric"`
+	Value    *float64 `json:"value"`
+	Games    int32    `json:"games"`
 }
 
-func (c *Client) GetAverageDPM(class string, minGames int) (results []Result, err error) {
-	var pipeline string
-	if class == "" {
-		pipeline = fmt.Sprintf(dpmAggregationTemplate, "$ne", "medic", minGames)
-	} else {
-		pipeline = fmt.Sprintf(dpmAggregationTemplate, "$eq", class, minGames)
-	}
-
-	var item bson.M
-	opts := options.Aggregate()
-
-	p, err := ParseMongoPipeline(pipeline)
-	if err != nil {
-		return nil, err
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	raw, err := json.Marshal(result(r))
+	if err != nil || r.Metric == "" {
+		return raw, err
 	}
-
-	playerNames, err := c.PlayerNames()
-	if err != nil {
+	fields := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(raw, &fields); err != nil {
 		return nil, err
 	}
+	fields[r.Metric] = fields["value"]
+	return json.Marshal(fields)
+}
 
-	cur, err := c.Conn.
-		Database(c.database).
-		Collection(c.games).Aggregate(c.ctx, p, opts)
+// steamID3 converts a SteamID64, leaving IDs that can't be parsed empty.
+func steamID3(steamID64 string) string {
+	id, err := steamid.Parse(steamID64)
 	if err != nil {
-		return nil, err
+		return ""
 	}
+	return id.SteamID3()
+}
 
-	for cur.Next(c.ctx) {
-		r := &Result{}
-		if err = cur.Decode(&item); err != nil {
-			return nil, err
-		}
-		r.SteamID64 = item["_id"].(string)
-		dpm := item["dpm"].(float64)
-		r.DPM = &dpm
-		r.Games = item["games"].(int32)
-		r.PlayerName = playerNames[r.SteamID64].Name
-		r.Avatar = playerNames[r.SteamID64].Avatar
-		if err != nil {
-			return nil, err
+// assignRanks numbers sorted results starting from 1. Players with equal
+// values share a rank and the following rank is skipped (1, 2, 2, 4).
+func assignRanks(results []Result) {
+	for i := range results {
+		if i > 0 && sameValue(results[i].Value, results[i-1].Value) {
+			results[i].Rank = results[i-1].Rank
+		} else {
+			results[i].Rank = i + 1
 		}
-		results = append(results, *r)
 	}
-	return results, nil
 }
 
-func (c *Client) GetAverageKDR(class string, minGames int) (results []Result, err error) {
-	var pipeline string
-	if class == "" {
-		pipeline = fmt.Sprintf(kdrAggregationTemplate, "$ne", "medic", minGames)
-	} else {
-		pipeline = fmt.Sprintf(kdrAggregationTemplate, "$eq", class, minGames)
+func sameValue(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
+	return *a == *b
+}
 
-	var item bson.M
-	opts := options.Aggregate()
+func (r *Result) SetName(name string) {
+	r.PlayerName = name
+}
 
-	p, err := ParseMongoPipeline(pipeline)
+// NewClient connects to MongoDB, ctx only bounds connecting. Summaries collection
+// is optional, ratings are aggregated from games when it's empty.
+func NewClient(ctx context.Context, dsn, database, gamesCollection, namesCollection, summariesCollection string) (*Client, error) {
+	conn, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
 	if err != nil {
 		return nil, err
 	}
-
-	playerNames, err := c.PlayerNames()
-	if err != nil {
-		return nil, err
+	c := &Client{
+		database:  database,
+		games:     gamesCollection,
+		names:     namesCollection,
+		summaries: summariesCollection,
+		Conn:      conn,
+		quality:   newQualityLog(),
 	}
+	c.Players = newPlayerDirectory(c)
+	return c, nil
+}
 
-	cur, err := c.Conn.
-		Database(c.database).
-		Collection(c.games).Aggregate(c.ctx, p, opts)
-	if err != nil {
-		return nil, err
+// GetRating aggregates a metric for every player who played more than q.MinGames games.
+// Results are sorted best first and ranked. Summarizable ratings without a time window are
+// read from the summaries collection combined with games not summarized yet, see summariesUsable.
+func (c *Client) GetRating(ctx context.Context, q RatingQuery) ([]Result, error) {
+	metric, ok := LookupMetric(q.Metric)
+	if !ok {
+		return nil, ErrUnknownMetric
 	}
-
-	for cur.Next(c.ctx) {
-		r := &Result{}
-		if err = cur.Decode(&item); err != nil {
-			return nil, err
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if q.Window.IsZero() && metric.Summarizable() {
+		if usable, err := c.summariesUsable(ctx); err == nil && usable {
+			p := append(NewPipeline().UnionWith(c.games, unsummarizedGames()).Build(), metric.pipeline(q, summarySource)...)
+			results, err := c.aggregateRating(ctx, c.summaries, metric, q, summarySource, p)
+			if err == nil {
+				return results, nil
+			}
 		}
-		r.SteamID64 = item["_id"].(string)
-		kdr := item["kdr"].(float64)
-		r.KDR = &kdr
-		r.Games = item["games"].(int32)
-		r.PlayerName = playerNames[r.SteamID64].Name
-		r.Avatar = playerNames[r.SteamID64].Avatar
-		results = append(results, *r)
 	}
-	return results, nil
+	results, err := c.aggregateRating(ctx, c.games, metric, q, gamesSource, metric.pipeline(q, gamesSource))
+	return results, queryError(err)
 }
 
-func (c *Client) GetAverageHealsPerMin(minGames int) (results []Result, err error) {
-	pipeline := fmt.Sprintf(healsPerMinAggregationTemplate, minGames)
-
-	var item bson.M
+// aggregateRating runs the metric pipeline p over the collection. Malformed rows are
+// skipped and reported in DataQuality.
+func (c *Client) aggregateRating(ctx context.Context, collection string, metric Metric, q RatingQuery, src source, p mongo.Pipeline) (results []Result, err error) {
 	opts := options.Aggregate()
 
-	p, err := ParseMongoPipeline(pipeline)
-	if err != nil {
-		return nil, err
-	}
+	start := time.Now()
+	defer func() {
+		monitoring.AggregationDuration.WithLabelValues(metric.Name, src.name).Observe(time.Since(start).Seconds())
+	}()
 
 	cur, err := c.Conn.
 		Database(c.database).
-		Collection(c.games).Aggregate(c.ctx, p, opts)
-	if err != nil {
-		return nil, err
-	}
-
-	playerNames, err := c.PlayerNames()
+		Collection(collection).Aggregate(ctx, p, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	for cur.Next(c.ctx) {
-		r := &Result{}
-		if err = cur.Decode(&item); err != nil {
-			return nil, err
+	for cur.Next(ctx) {
+		r, problem := decodeResult(cur.Current, metric.Name, q.ZeroPolicy == ZeroNull)
+		if problem != "" {
+			id := r.SteamID64
+			if id == "" {
+				id = rawID(cur.Current.Lookup("_id"))
+			}
+			c.quality.add(collection, id, metric.Name+": "+problem)
+			continue
+		}
+		player, _ := c.Players.Lookup(r.SteamID64)
+		if q.Site != "" && !player.OnSite(q.Site) {
+			continue
 		}
-		r.SteamID64 = item["_id"].(string)
-		hpm := item["hpm"].(float64)
-		r.HPM = &hpm
-		r.Games = item["games"].(int32)
-		r.PlayerName = playerNames[r.SteamID64].Name
-		r.Avatar = playerNames[r.SteamID64].Avatar
-		results = append(results, *r)
+		r.PlayerName = player.Name
+		r.Avatar = player.Avatar
+		r.SteamID3 = steamID3(r.SteamID64)
+		results = append(results, r)
+	}
+	if err = cur.Err(); err != nil {
+		return nil, err
 	}
+	assignRanks(results)
 	return results, nil
 }
 
-func (c *Client) PlayerNames() (map[string]Player, error) {
+// PlayerNames loads the whole names collection. Use Players for lookups.
+// Documents without a readable SteamID are skipped, all problems are reported in DataQuality.
+// Of duplicate documents of a SteamID the newest by _id wins.
+func (c *Client) PlayerNames(ctx context.Context) (map[string]Player, error) {
 	users := make(map[string]Player)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
 	cur, err := c.Conn.
 		Database(c.database).
 		Collection(c.names).
-		Find(c.ctx, bson.M{})
+		Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
 	if err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
 
-	var item bson.M
-
-	for cur.Next(c.ctx) {
-		if err = cur.Decode(&item); err != nil {
-			return nil, err
+	c.quality.reset(c.names)
+	for cur.Next(ctx) {
+		steamID, player, problem := decodePlayer(cur.Current)
+		if problem != "" {
+			id := steamID
+			if id == "" {
+				id = rawID(cur.Current.Lookup("steam_id"))
+			}
+			c.quality.add(c.names, id, problem)
 		}
-		steamID := item["steam_id"].(string)
-		name := item["name"].(string)
-		avatar := item["avatar"].(bson.M)
-		small := avatar["small"].(string)
-		users[steamID] = Player{
-			Name:   name,
-			Avatar: small,
+		if steamID != "" {
+			users[steamID] = player
 		}
 	}
+	if err = cur.Err(); err != nil {
+		return nil, queryError(err)
+	}
 	return users, nil
 }
 
-func (c *Client) GetGamesCount() (int64, error) {
-	return c.Conn.
+// EstimateGamesCount reads the games count from collection metadata instead of counting
+// documents, it suits frequent polling like metrics scrapes.
+func (c *Client) EstimateGamesCount(ctx context.Context) (int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	count, err := c.Conn.
 		Database(c.database).
 		Collection(c.games).
-		CountDocuments(c.ctx, bson.D{})
+		EstimatedDocumentCount(ctx)
+	return count, queryError(err)
+}
+
+func (c *Client) GetGamesCount(ctx context.Context) (int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	count, err := c.Conn.
+		Database(c.database).
+		Collection(c.games).
+		CountDocuments(ctx, bson.D{})
+	return count, queryError(err)
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.QueryTimeout)
+}
+
+// queryError marks errors caused by an exceeded deadline with ErrQueryTimeout.
+func queryError(err error) error {
+	if err != nil && (errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)) {
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	}
+	return err
 }
 
+// ParseMongoPipeline parses a pipeline written in Extended JSON.
+// It is meant for queries supplied by admins, not for building pipelines from user input.
 func ParseMongoPipeline(str string) (pipeline mongo.Pipeline, err error) {
 	str = strings.TrimSpace(str)
 	if strings.Index(str, "[") != 0 {
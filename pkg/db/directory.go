@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// changeDebounce batches bursts of name changes, e.g. while playerResolver runs,
+// into a single refresh.
+const changeDebounce = 5 * time.Second
+
+// PlayerDirectory keeps player names and avatars in memory, so ratings
+// don't have to scan the names collection on every request.
+type PlayerDirectory struct {
+	client *Client
+
+	mu       sync.RWMutex
+	players  map[string]Player
+	loadedAt time.Time
+	// changes counts refreshes that changed any player, it is part of Client.DataVersion
+	// so cached ratings pick up new names, avatars and sites.
+	changes uint64
+}
+
+type DirectoryStatus struct {
+	Size       int       `json:"size"`
+	LoadedAt   time.Time `json:"loaded_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+func newPlayerDirectory(c *Client) *PlayerDirectory {
+	return &PlayerDirectory{
+		client:  c,
+		players: make(map[string]Player),
+	}
+}
+
+// Refresh reloads the whole names collection.
+func (d *PlayerDirectory) Refresh(ctx context.Context) error {
+	players, err := d.client.PlayerNames(ctx)
+	if err != nil {
+		return err
+	}
+	d.set(players, time.Now())
+	return nil
+}
+
+func (d *PlayerDirectory) set(players map[string]Player, loadedAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !reflect.DeepEqual(players, d.players) {
+		d.changes++
+	}
+	d.players = players
+	d.loadedAt = loadedAt
+}
+
+func (d *PlayerDirectory) version() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.changes
+}
+
+// Lookup returns player by SteamID64. Unknown players are returned empty.
+func (d *PlayerDirectory) Lookup(steamID string) (Player, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	p, ok := d.players[steamID]
+	return p, ok
+}
+
+func (d *PlayerDirectory) Status() DirectoryStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s := DirectoryStatus{Size: len(d.players), LoadedAt: d.loadedAt}
+	if !d.loadedAt.IsZero() {
+		s.AgeSeconds = time.Since(d.loadedAt).Seconds()
+	}
+	return s
+}
+
+// Run loads the directory and keeps it fresh until ctx is done. It refreshes
+// every interval and, when MongoDB supports change streams, right after names change.
+func (d *PlayerDirectory) Run(ctx context.Context, interval time.Duration, log logrus.FieldLogger) {
+	changed := make(chan struct{}, 1)
+	go d.client.watch(ctx, d.client.names, changed, log)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Refresh(ctx); err != nil {
+			log.Errorf("Failed to refresh player names: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(changeDebounce):
+			}
+			select {
+			case <-changed:
+			default:
+			}
+		}
+	}
+}
+
+// watch signals changed on every change of the collection until ctx is done.
+// It gives up quietly when MongoDB doesn't support change streams (standalone servers).
+func (c *Client) watch(ctx context.Context, collection string, changed chan<- struct{}, log logrus.FieldLogger) {
+	stream, err := c.Conn.
+		Database(c.database).
+		Collection(collection).
+		Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		log.Infof("Change stream on %s unavailable, falling back to polling: %v", collection, err)
+		return
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if err = stream.Err(); err != nil && ctx.Err() == nil {
+		log.Errorf("Change stream on %s stopped: %v", collection, err)
+	}
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDirectoryVersion(t *testing.T) {
+	d := newPlayerDirectory(nil)
+	v := d.version()
+
+	d.set(map[string]Player{"1": {Name: "A"}}, time.Now())
+	if d.version() == v {
+		t.Fatal("loading players didn't change the version")
+	}
+	v = d.version()
+
+	d.set(map[string]Player{"1": {Name: "A"}}, time.Now())
+	if d.version() != v {
+		t.Error("reloading the same players changed the version")
+	}
+
+	d.set(map[string]Player{"1": {Name: "A2"}}, time.Now())
+	if d.version() == v {
+		t.Error("renaming a player didn't change the version")
+	}
+}
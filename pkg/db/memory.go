@@ -0,0 +1,218 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MemoryStore evaluates the same queries as Client over games kept in memory.
+type MemoryStore struct {
+	games    []Game
+	players  map[string]Player
+	loadedAt time.Time
+}
+
+func NewMemoryStore(games []Game, players map[string]Player) *MemoryStore {
+	return &MemoryStore{
+		games:    games,
+		players:  players,
+		loadedAt: time.Now(),
+	}
+}
+
+type playerTotals struct {
+	numerator, denominator float64
+	games                  int
+}
+
+func (s *MemoryStore) GetRating(ctx context.Context, q RatingQuery) ([]Result, error) {
+	metric, ok := LookupMetric(q.Metric)
+	if !ok {
+		return nil, ErrUnknownMetric
+	}
+
+	totals := make(map[string]*playerTotals)
+	for _, g := range s.games {
+		if !metric.matchesClass(q.Class, g.Player.Class) || !q.Window.contains(g.Date) || !metric.counts(g) {
+			continue
+		}
+		t, ok := totals[g.Player.SteamID]
+		if !ok {
+			t = &playerTotals{}
+			totals[g.Player.SteamID] = t
+		}
+		t.games++
+		for _, f := range metric.Numerator {
+			v, _ := g.field(f)
+			t.numerator += v
+		}
+		switch {
+		case metric.PerMinute:
+			v, _ := g.field("length")
+			t.denominator += v
+		case metric.Denominator != "":
+			v, _ := g.field(metric.Denominator)
+			t.denominator += v
+		default:
+			t.denominator++
+		}
+	}
+
+	results := make([]Result, 0, len(totals))
+	for steamID, t := range totals {
+		player := s.players[steamID]
+		if t.games <= q.MinGames || (q.Site != "" && !player.OnSite(q.Site)) {
+			continue
+		}
+		denominator := t.denominator
+		if metric.PerMinute {
+			denominator /= 60
+		}
+		var value *float64
+		switch {
+		case denominator != 0:
+			v := round(t.numerator/denominator, metric.Precision)
+			value = &v
+		case q.ZeroPolicy == ZeroAsOne:
+			v := round(t.numerator, metric.Precision)
+			value = &v
+		case q.ZeroPolicy != ZeroNull:
+			continue
+		}
+		results = append(results, Result{
+			PlayerName: player.Name,
+			Avatar:     player.Avatar,
+			SteamID64:  steamID,
+			SteamID3:   steamID3(steamID),
+			Metric:     metric.Name,
+			Value:      value,
+			Games:      int32(t.games),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if (a.Value == nil) != (b.Value == nil) {
+			return b.Value == nil
+		}
+		if a.Value != nil && *a.Value != *b.Value {
+			return (*a.Value > *b.Value) != metric.Ascending
+		}
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return a.SteamID64 < b.SteamID64
+	})
+	assignRanks(results)
+	return results, nil
+}
+
+func (s *MemoryStore) GetPlayerProfile(ctx context.Context, steamID string) (*Profile, error) {
+	byClass := make(map[string]*classTotals)
+	for _, g := range s.games {
+		if g.Player.SteamID != steamID {
+			continue
+		}
+		t, ok := byClass[g.Player.Class]
+		if !ok {
+			t = &classTotals{Class: g.Player.Class, FirstGame: g.Date, LastGame: g.Date}
+			byClass[g.Player.Class] = t
+		}
+		t.Damage += g.Stats.DamageDone
+		t.Kills += g.Stats.Kills
+		t.Deaths += g.Stats.Deaths
+		t.Heals += g.Stats.Healed
+		t.Playtime += g.Length
+		t.Games++
+		if g.Date.Before(t.FirstGame) {
+			t.FirstGame = g.Date
+		}
+		if g.Date.After(t.LastGame) {
+			t.LastGame = g.Date
+		}
+	}
+	if len(byClass) == 0 {
+		return nil, ErrPlayerNotFound
+	}
+
+	totals := make([]classTotals, 0, len(byClass))
+	for _, t := range byClass {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Games != totals[j].Games {
+			return totals[i].Games > totals[j].Games
+		}
+		return totals[i].Class < totals[j].Class
+	})
+
+	return newProfile(steamID, s.players[steamID], totals), nil
+}
+
+func (s *MemoryStore) GetPlayerGames(ctx context.Context, steamID string, after *GameCursor, limit int) ([]Game, *GameCursor, error) {
+	games := make([]Game, 0)
+	for _, g := range s.games {
+		if g.Player.SteamID != steamID || (after != nil && !after.precedes(g)) {
+			continue
+		}
+		g.Class = g.Player.Class
+		games = append(games, g)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return GameCursor{Date: games[i].Date, ID: games[i].ID}.precedes(games[j])
+	})
+
+	if len(games) <= limit {
+		return games, nil, nil
+	}
+	games = games[:limit]
+	last := games[limit-1]
+	return games, &GameCursor{Date: last.Date, ID: last.ID}, nil
+}
+
+func (s *MemoryStore) GetGamesCount(ctx context.Context) (int64, error) {
+	return int64(len(s.games)), nil
+}
+
+func (s *MemoryStore) PlayersStatus() DirectoryStatus {
+	return DirectoryStatus{
+		Size:       len(s.players),
+		LoadedAt:   s.loadedAt,
+		AgeSeconds: time.Since(s.loadedAt).Seconds(),
+	}
+}
+
+func (s *MemoryStore) DataVersion(ctx context.Context) (string, error) {
+	return strconv.Itoa(len(s.games)), nil
+}
+
+// DataQuality is always clean, games in memory are well-formed.
+func (s *MemoryStore) DataQuality() QualityReport {
+	return QualityReport{Issues: []DocumentIssue{}}
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// precedes reports whether the game comes after the cursor in newest first order.
+func (gc GameCursor) precedes(g Game) bool {
+	if !g.Date.Equal(gc.Date) {
+		return g.Date.Before(gc.Date)
+	}
+	return bytes.Compare(g.ID[:], gc.ID[:]) < 0
+}
+
+func (m Metric) matchesClass(class, gameClass string) bool {
+	switch {
+	case class != "":
+		return gameClass == class
+	case len(m.Classes) > 0:
+		return m.AllowsClass(gameClass)
+	default:
+		return gameClass != "medic"
+	}
+}
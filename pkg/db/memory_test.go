@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGameFieldsCoverMetrics(t *testing.T) {
+	for _, f := range SummaryFields() {
+		if _, ok := (Game{}).field(f); !ok {
+			t.Errorf("Game.field doesn't know %s", f)
+		}
+	}
+}
+
+func TestSummaryStateHasFields(t *testing.T) {
+	if !(SummaryState{Fields: SummaryFields()}).HasFields() {
+		t.Error("state with the current fields is refused")
+	}
+	if (SummaryState{}).HasFields() {
+		t.Error("state without fields is accepted")
+	}
+	if (SummaryState{Fields: SummaryFields()[1:]}).HasFields() {
+		t.Error("state missing a field is accepted")
+	}
+}
+
+func TestAscendingMetricsSkipMissingFields(t *testing.T) {
+	taken, buildTime := int64(600), 40.0
+	games := []Game{
+		{Length: 600, Player: GamePlayer{SteamID: "1", Class: "medic"}, Stats: GameStats{DamageTaken: &taken, Ubers: 2, UberBuildTime: &buildTime}},
+		{Length: 600, Player: GamePlayer{SteamID: "1", Class: "medic"}},
+		{Length: 600, Player: GamePlayer{SteamID: "1", Class: "medic"}, Stats: GameStats{UberBuildTime: &buildTime}},
+	}
+	s := NewMemoryStore(games, nil)
+
+	for _, tt := range []struct {
+		metric string
+		value  float64
+	}{
+		{"dtm", 60},
+		{"uber_build_time", 40},
+	} {
+		results, err := s.GetRating(context.Background(), RatingQuery{Metric: tt.metric, Class: "medic"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Games != 1 || *results[0].Value != tt.value {
+			t.Errorf("%s: got %+v, want %v over the one game with the fields", tt.metric, results, tt.value)
+		}
+	}
+	results, _ := s.GetRating(context.Background(), RatingQuery{Metric: "drops", Class: "medic"})
+	if len(results) != 0 {
+		t.Errorf("drops: got %+v, want no games counted", results)
+	}
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnknownMetric = errors.New("unknown metric")
+	ErrBadZeroPolicy = errors.New("invalid zero denominator policy: must be skip, one or null")
+)
+
+// ZeroPolicy decides the value of a ratio whose denominator is zero,
+// e.g. KDR of a player who never died or DPM over zero-length games.
+type ZeroPolicy string
+
+const (
+	// ZeroSkip leaves such players out of the rating. It is the default.
+	ZeroSkip ZeroPolicy = "skip"
+	// ZeroAsOne divides by one instead, e.g. KDR equals kills.
+	ZeroAsOne ZeroPolicy = "one"
+	// ZeroNull keeps such players with a null value, ranked last.
+	ZeroNull ZeroPolicy = "null"
+)
+
+// ParseZeroPolicy accepts skip, one or null. Empty string means ZeroSkip.
+func ParseZeroPolicy(s string) (ZeroPolicy, error) {
+	switch p := ZeroPolicy(s); p {
+	case "":
+		return ZeroSkip, nil
+	case ZeroSkip, ZeroAsOne, ZeroNull:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w, got %q", ErrBadZeroPolicy, s)
+	}
+}
+
+// Metric describes a rating computed from the games collection.
+type Metric struct {
+	// Name identifies the metric in the API and in results.
+	Name string
+	// Title is a human readable name.
+	Title string
+	// Numerator lists the games fields summed per player.
+	Numerator []string
+	// Denominator is the games field the numerator is divided by.
+	// When empty, the numerator is divided by the number of games.
+	Denominator string
+	// PerMinute divides the numerator by total playtime in minutes.
+	PerMinute bool
+	// Ascending ranks lower values first, e.g. for damage taken.
+	Ascending bool
+	// Requires lists games fields a game must have to count. Older logs lack some fields,
+	// and summing them as zero would rank such games best in ascending metrics.
+	Requires []string
+	// RequiresPositive lists games fields that must be above zero for a game to count,
+	// e.g. uber build time of a game without ubers.
+	RequiresPositive []string
+	// Classes limits the metric to these classes. When empty, any class
+	// can be requested and the default is every class except medic.
+	Classes []string
+	// Precision is the number of decimal places kept.
+	Precision int
+}
+
+// RatingQuery selects a rating of one metric.
+type RatingQuery struct {
+	Metric   string
+	Class    string
+	MinGames int
+	Window   TimeWindow
+	// ZeroPolicy applies to players whose denominator sums up to zero, empty means ZeroSkip.
+	ZeroPolicy ZeroPolicy
+	// Site limits the rating to players of a tf2pickup site, all players when empty.
+	Site string
+}
+
+// Classes lists player classes stats are collected for.
+var Classes = []string{"scout", "soldier", "demoman", "medic"}
+
+func ValidClass(class string) bool {
+	for _, c := range Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+var metrics = []Metric{
+	{Name: "dpm", Title: "DPM", Numerator: []string{"stats.damage_done"}, PerMinute: true, Precision: 2},
+	{Name: "kdr", Title: "KDR", Numerator: []string{"stats.kills"}, Denominator: "stats.deaths", Precision: 1},
+	{Name: "hpm", Title: "Heals per minute", Numerator: []string{"stats.healed"}, PerMinute: true, Classes: []string{"medic"}, Precision: 2},
+	{Name: "dtm", Title: "Damage taken per minute", Numerator: []string{"stats.damage_taken"}, PerMinute: true, Ascending: true,
+		Requires: []string{"stats.damage_taken"}, Precision: 2},
+	{Name: "assists", Title: "Assists per game", Numerator: []string{"stats.assists"}, Precision: 2},
+	{Name: "kad", Title: "KA/D", Numerator: []string{"stats.kills", "stats.assists"}, Denominator: "stats.deaths", Precision: 1},
+	{Name: "ubers", Title: "Ubers per game", Numerator: []string{"stats.ubers"}, Classes: []string{"medic"}, Precision: 2},
+	{Name: "drops", Title: "Drops per game", Numerator: []string{"stats.drops"}, Classes: []string{"medic"}, Ascending: true,
+		Requires: []string{"stats.drops"}, Precision: 2},
+	{Name: "uber_build_time", Title: "Uber build time", Numerator: []string{"stats.uber_build_time"}, Classes: []string{"medic"}, Ascending: true,
+		Requires: []string{"stats.uber_build_time"}, RequiresPositive: []string{"stats.ubers"}, Precision: 1},
+	{Name: "airshots", Title: "Airshots per game", Numerator: []string{"stats.airshots"}, Classes: []string{"soldier", "demoman"}, Precision: 2},
+	{Name: "captures", Title: "Captures per game", Numerator: []string{"stats.captures"}, Precision: 2},
+}
+
+// Metrics lists all registered metrics.
+func Metrics() []Metric {
+	return metrics
+}
+
+func LookupMetric(name string) (Metric, bool) {
+	for _, m := range metrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
+
+// AllowsClass reports whether the metric can be filtered by class.
+// Empty class is always allowed.
+func (m Metric) AllowsClass(class string) bool {
+	if class == "" || len(m.Classes) == 0 {
+		return true
+	}
+	for _, c := range m.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarizable reports whether the metric can be read from summaries, which
+// can't tell which games had the fields the metric requires.
+func (m Metric) Summarizable() bool {
+	return len(m.Requires) == 0 && len(m.RequiresPositive) == 0
+}
+
+// requireFilter matches games the metric counts, see Requires.
+func (m Metric) requireFilter() bson.D {
+	var filter bson.D
+	for _, f := range m.Requires {
+		filter = append(filter, bson.E{Key: f, Value: bson.D{{Key: "$exists", Value: true}}})
+	}
+	for _, f := range m.RequiresPositive {
+		filter = append(filter, bson.E{Key: f, Value: bson.D{{Key: "$gt", Value: 0}}})
+	}
+	return filter
+}
+
+// counts reports whether the metric counts g, as requireFilter does.
+func (m Metric) counts(g Game) bool {
+	for _, f := range m.Requires {
+		if !g.has(f) {
+			return false
+		}
+	}
+	for _, f := range m.RequiresPositive {
+		if v, _ := g.field(f); v <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Metric) classFilter(class string) bson.D {
+	switch {
+	case class != "":
+		return bson.D{{Key: "$eq", Value: class}}
+	case len(m.Classes) > 0:
+		return bson.D{{Key: "$in", Value: m.Classes}}
+	default:
+		return bson.D{{Key: "$ne", Value: "medic"}}
+	}
+}
+
+// source maps metric fields onto the documents a rating is aggregated from.
+type source struct {
+	// name labels the source in monitoring.
+	name string
+	// class and steamID are paths of player class and SteamID64.
+	class, steamID string
+	// field converts a games field into an expression over the source documents.
+	field func(name string) string
+	// games is summed to count games.
+	games interface{}
+	// perGame is set for sources of single games, which can be matched by Metric.Requires.
+	perGame bool
+}
+
+var (
+	gamesSource = source{
+		name:    "games",
+		class:   "player.class",
+		steamID: "player.steam_id",
+		field:   func(name string) string { return "$" + name },
+		games:   1,
+		perGame: true,
+	}
+	summarySource = source{
+		name:    "summaries",
+		class:   "class",
+		steamID: "steam_id",
+		field:   func(name string) string { return "$sums." + SummaryKey(name) },
+		games:   "$games",
+	}
+)
+
+func (m Metric) pipeline(q RatingQuery, src source) mongo.Pipeline {
+	match := bson.D{{Key: src.class, Value: m.classFilter(q.Class)}}
+	match = append(match, q.Window.filter()...)
+	if src.perGame {
+		match = append(match, m.requireFilter()...)
+	}
+
+	var numerator interface{} = src.field(m.Numerator[0])
+	if len(m.Numerator) > 1 {
+		fields := make(bson.A, len(m.Numerator))
+		for i, f := range m.Numerator {
+			fields[i] = src.field(f)
+		}
+		numerator = bson.D{{Key: "$add", Value: fields}}
+	}
+
+	var denominator, divisor interface{}
+	switch {
+	case m.PerMinute:
+		denominator = src.field("length")
+		divisor = bson.D{{Key: "$divide", Value: bson.A{"$sum_denominator", 60}}}
+	case m.Denominator != "":
+		denominator, divisor = src.field(m.Denominator), "$sum_denominator"
+	default:
+		denominator, divisor = src.games, "$sum_denominator"
+	}
+
+	order := -1
+	if m.Ascending {
+		order = 1
+	}
+
+	// $cond only evaluates the chosen branch, so $divide never sees a zero divisor.
+	var zeroValue interface{}
+	if q.ZeroPolicy == ZeroAsOne {
+		zeroValue = bson.D{{Key: "$round", Value: bson.A{"$sum_numerator", m.Precision}}}
+	}
+	project := bson.D{
+		{Key: "value", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{"$sum_denominator", 0}}},
+			zeroValue,
+			bson.D{{Key: "$round", Value: bson.A{
+				bson.D{{Key: "$divide", Value: bson.A{"$sum_numerator", divisor}}},
+				m.Precision,
+			}}},
+		}}}},
+		{Key: "games", Value: "$count_games"},
+	}
+	// _id (the SteamID) makes the order of ties stable across pages, as in MemoryStore
+	sort := bson.D{{Key: "value", Value: order}, {Key: "games", Value: -1}, {Key: "_id", Value: 1}}
+	filter := bson.D{{Key: "games", Value: bson.D{{Key: "$gt", Value: q.MinGames}}}}
+	switch q.ZeroPolicy {
+	case ZeroNull:
+		// nulls sort before numbers, rank them last whatever the order
+		project = append(project, bson.E{Key: "defined", Value: bson.D{{Key: "$ne", Value: bson.A{"$sum_denominator", 0}}}})
+		sort = append(bson.D{{Key: "defined", Value: -1}}, sort...)
+	case ZeroAsOne:
+	default:
+		filter = append(filter, bson.E{Key: "value", Value: bson.D{{Key: "$ne", Value: nil}}})
+	}
+
+	return NewPipeline().
+		Match(match).
+		Group("$"+src.steamID, bson.D{
+			{Key: "sum_numerator", Value: bson.D{{Key: "$sum", Value: numerator}}},
+			{Key: "sum_denominator", Value: bson.D{{Key: "$sum", Value: denominator}}},
+			{Key: "count_games", Value: bson.D{{Key: "$sum", Value: src.games}}},
+		}).
+		Project(project).
+		Sort(sort).
+		Match(filter).
+		Build()
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PipelineBuilder assembles aggregation pipelines from typed stages,
+// so user input always ends up as a value and never as pipeline syntax.
+type PipelineBuilder struct {
+	stages mongo.Pipeline
+}
+
+func NewPipeline() *PipelineBuilder {
+	return &PipelineBuilder{}
+}
+
+func (b *PipelineBuilder) stage(name string, value interface{}) *PipelineBuilder {
+	b.stages = append(b.stages, bson.D{{Key: name, Value: value}})
+	return b
+}
+
+func (b *PipelineBuilder) Match(filter bson.D) *PipelineBuilder {
+	return b.stage("$match", filter)
+}
+
+// Group groups documents by id, fields hold the accumulators.
+func (b *PipelineBuilder) Group(id interface{}, fields bson.D) *PipelineBuilder {
+	return b.stage("$group", append(bson.D{{Key: "_id", Value: id}}, fields...))
+}
+
+func (b *PipelineBuilder) Project(fields bson.D) *PipelineBuilder {
+	return b.stage("$project", fields)
+}
+
+func (b *PipelineBuilder) Sort(fields bson.D) *PipelineBuilder {
+	return b.stage("$sort", fields)
+}
+
+func (b *PipelineBuilder) Limit(n int64) *PipelineBuilder {
+	return b.stage("$limit", n)
+}
+
+// UnionWith adds the results of p run over another collection of the same database.
+func (b *PipelineBuilder) UnionWith(collection string, p mongo.Pipeline) *PipelineBuilder {
+	return b.stage("$unionWith", bson.D{{Key: "coll", Value: collection}, {Key: "pipeline", Value: p}})
+}
+
+func (b *PipelineBuilder) Build() mongo.Pipeline {
+	return b.stages
+}
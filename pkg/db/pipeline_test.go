@@ -0,0 +1,120 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPipelineBuilder(t *testing.T) {
+	p := NewPipeline().
+		Match(bson.D{{Key: "a", Value: 1}}).
+		Group("$b", bson.D{{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}).
+		Sort(bson.D{{Key: "n", Value: -1}}).
+		Limit(5).
+		Build()
+
+	want := []string{"$match", "$group", "$sort", "$limit"}
+	if len(p) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(p), len(want))
+	}
+	for i, stage := range p {
+		if stage[0].Key != want[i] {
+			t.Errorf("stage %d: got %s, want %s", i, stage[0].Key, want[i])
+		}
+	}
+	group := p[1][0].Value.(bson.D)
+	if group[0].Key != "_id" || group[0].Value != "$b" {
+		t.Errorf("group must start with _id, got %v", group[0])
+	}
+}
+
+func TestMetricPipelineClassInjection(t *testing.T) {
+	dpm, _ := LookupMetric("dpm")
+	benign := dpm.pipeline(RatingQuery{Metric: "dpm", Class: "scout", MinGames: 10}, gamesSource)
+
+	tests := []struct {
+		name  string
+		class string
+	}{
+		{"json breakout", `scout"}}, {"$limit": 1}, {"$match": {"a": "`},
+		{"operator", "$ne"},
+		{"field path", "$player.steam_id"},
+		{"where", `"; return true; var a = "`},
+		{"extended json", `{"$gt": ""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := dpm.pipeline(RatingQuery{Metric: "dpm", Class: tt.class, MinGames: 10}, gamesSource)
+
+			if len(p) != len(benign) {
+				t.Fatalf("got %d stages, want %d", len(p), len(benign))
+			}
+			for i := range p {
+				if p[i][0].Key != benign[i][0].Key {
+					t.Errorf("stage %d: got %s, want %s", i, p[i][0].Key, benign[i][0].Key)
+				}
+			}
+
+			wantMatch := bson.D{{Key: "player.class", Value: bson.D{{Key: "$eq", Value: tt.class}}}}
+			if got := p[0][0].Value; !reflect.DeepEqual(got, wantMatch) {
+				t.Errorf("got match %v, want %v", got, wantMatch)
+			}
+			if !reflect.DeepEqual(p[1:], benign[1:]) {
+				t.Errorf("class value changed stages after $match")
+			}
+		})
+	}
+}
+
+func TestMetricPipelineStableSort(t *testing.T) {
+	for _, m := range Metrics() {
+		for _, policy := range []ZeroPolicy{ZeroSkip, ZeroAsOne, ZeroNull} {
+			for _, src := range []source{gamesSource, summarySource} {
+				var sort bson.D
+				for _, stage := range m.pipeline(RatingQuery{Metric: m.Name, ZeroPolicy: policy}, src) {
+					if stage[0].Key == "$sort" {
+						sort = stage[0].Value.(bson.D)
+					}
+				}
+				if len(sort) == 0 || sort[len(sort)-1] != (bson.E{Key: "_id", Value: 1}) {
+					t.Errorf("%s %s over %s: sort %v doesn't end with _id", m.Name, policy, src.name, sort)
+				}
+			}
+		}
+	}
+}
+
+func TestUnsummarizedGamesShape(t *testing.T) {
+	p := unsummarizedGames()
+	if want := (bson.D{{Key: SummaryBatchField, Value: nil}}); !reflect.DeepEqual(p[0][0].Value, want) {
+		t.Errorf("got match %v, want %v", p[0][0].Value, want)
+	}
+	project := p[1][0].Value.(bson.D).Map()
+	for _, key := range []string{summarySource.steamID, summarySource.class, "games"} {
+		if _, ok := project[key]; !ok {
+			t.Errorf("project lacks %s read by summarySource", key)
+		}
+	}
+	sums := project["sums"].(bson.D).Map()
+	for _, f := range SummaryFields() {
+		if sums[SummaryKey(f)] != "$"+f {
+			t.Errorf("got sums.%s = %v, want $%s", SummaryKey(f), sums[SummaryKey(f)], f)
+		}
+	}
+}
+
+func TestMetricPipelineRequires(t *testing.T) {
+	m, _ := LookupMetric("uber_build_time")
+	match := m.pipeline(RatingQuery{Metric: m.Name}, gamesSource)[0][0].Value.(bson.D).Map()
+	if !reflect.DeepEqual(match["stats.uber_build_time"], bson.D{{Key: "$exists", Value: true}}) {
+		t.Errorf("got %v, want games without uber build time skipped", match["stats.uber_build_time"])
+	}
+	if !reflect.DeepEqual(match["stats.ubers"], bson.D{{Key: "$gt", Value: 0}}) {
+		t.Errorf("got %v, want games without ubers skipped", match["stats.ubers"])
+	}
+	if m.Summarizable() {
+		t.Error("metric with requirements is read from summaries")
+	}
+}
@@ -0,0 +1,328 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPlayerNotFound = errors.New("player not found")
+	ErrBadCursor      = errors.New("invalid cursor")
+)
+
+type ClassStats struct {
+	Class    string   `json:"class"`
+	Games    int64    `json:"games"`
+	Playtime int64    `json:"playtime"`
+	DPM      *float64 `json:"dpm,omitempty"`
+	KDR      *float64 `json:"kdr,omitempty"`
+	HPM      *float64 `json:"hpm,omitempty"`
+}
+
+type Profile struct {
+	PlayerName string       `json:"player_name"`
+	Avatar     string       `json:"avatar"`
+	SteamID64  string       `json:"steamid64"`
+	Games      int64        `json:"games"`
+	Playtime   int64        `json:"playtime"`
+	FirstGame  time.Time    `json:"first_game"`
+	LastGame   time.Time    `json:"last_game"`
+	Classes    []ClassStats `json:"classes"`
+}
+
+// GameStats of a player. Fields that older logs lack are pointers, nil when missing.
+type GameStats struct {
+	DamageDone    int64    `bson:"damage_done" json:"damage_done"`
+	DamageTaken   *int64   `bson:"damage_taken" json:"damage_taken"`
+	Kills         int64    `bson:"kills" json:"kills"`
+	Deaths        int64    `bson:"deaths" json:"deaths"`
+	Assists       int64    `bson:"assists" json:"assists"`
+	Healed        int64    `bson:"healed" json:"healed"`
+	Ubers         int64    `bson:"ubers" json:"ubers"`
+	Drops         *int64   `bson:"drops" json:"drops"`
+	UberBuildTime *float64 `bson:"uber_build_time" json:"uber_build_time"`
+	Airshots      int64    `bson:"airshots" json:"airshots"`
+	Captures      int64    `bson:"captures" json:"captures"`
+}
+
+type GamePlayer struct {
+	SteamID string `bson:"steam_id"`
+	Class   string `bson:"class"`
+}
+
+// Game is a single player's record from one log.
+type Game struct {
+	ID     primitive.ObjectID `bson:"_id" json:"-"`
+	LogID  int64              `bson:"log_id" json:"log_id"`
+	Date   time.Time          `bson:"date" json:"date"`
+	Length int64              `bson:"length" json:"length"`
+	Player GamePlayer         `bson:"player" json:"-"`
+	Class  string             `bson:"-" json:"class"`
+	Stats  GameStats          `bson:"stats" json:"stats"`
+}
+
+// field returns the value of a games collection field, e.g. "stats.kills".
+// Fields missing from the game are zero, see has.
+func (g Game) field(name string) (float64, bool) {
+	switch name {
+	case "length":
+		return float64(g.Length), true
+	case "stats.damage_done":
+		return float64(g.Stats.DamageDone), true
+	case "stats.damage_taken":
+		return float64(int64OrZero(g.Stats.DamageTaken)), true
+	case "stats.kills":
+		return float64(g.Stats.Kills), true
+	case "stats.deaths":
+		return float64(g.Stats.Deaths), true
+	case "stats.assists":
+		return float64(g.Stats.Assists), true
+	case "stats.healed":
+		return float64(g.Stats.Healed), true
+	case "stats.ubers":
+		return float64(g.Stats.Ubers), true
+	case "stats.drops":
+		return float64(int64OrZero(g.Stats.Drops)), true
+	case "stats.uber_build_time":
+		if g.Stats.UberBuildTime == nil {
+			return 0, true
+		}
+		return *g.Stats.UberBuildTime, true
+	case "stats.airshots":
+		return float64(g.Stats.Airshots), true
+	case "stats.captures":
+		return float64(g.Stats.Captures), true
+	default:
+		return 0, false
+	}
+}
+
+// has reports whether the game has a field, as {field: {$exists: true}} would.
+func (g Game) has(name string) bool {
+	switch name {
+	case "stats.damage_taken":
+		return g.Stats.DamageTaken != nil
+	case "stats.drops":
+		return g.Stats.Drops != nil
+	case "stats.uber_build_time":
+		return g.Stats.UberBuildTime != nil
+	default:
+		_, ok := g.field(name)
+		return ok
+	}
+}
+
+func int64OrZero(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// GameCursor points at the last game of a page; the next page starts right after it.
+type GameCursor struct {
+	Date time.Time
+	ID   primitive.ObjectID
+}
+
+func (gc GameCursor) String() string {
+	raw := strconv.FormatInt(gc.Date.UnixNano(), 10) + ":" + gc.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseGameCursor(s string) (GameCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return GameCursor{}, ErrBadCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return GameCursor{}, ErrBadCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return GameCursor{}, ErrBadCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return GameCursor{}, ErrBadCursor
+	}
+	return GameCursor{Date: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+type classTotals struct {
+	Class     string    `bson:"_id"`
+	Damage    int64     `bson:"sum_damage"`
+	Kills     int64     `bson:"sum_kills"`
+	Deaths    int64     `bson:"sum_deaths"`
+	Heals     int64     `bson:"sum_heals"`
+	Playtime  int64     `bson:"sum_playtime"`
+	Games     int64     `bson:"count_games"`
+	FirstGame time.Time `bson:"first_game"`
+	LastGame  time.Time `bson:"last_game"`
+}
+
+// GetPlayerProfile returns per-class stats of a single player,
+// or ErrPlayerNotFound if the player has no games.
+func (c *Client) GetPlayerProfile(ctx context.Context, steamID string) (*Profile, error) {
+	sum := func(field interface{}) bson.D {
+		return bson.D{{Key: "$sum", Value: field}}
+	}
+	p := NewPipeline().
+		Match(bson.D{{Key: "player.steam_id", Value: steamID}}).
+		Group("$player.class", bson.D{
+			{Key: "sum_damage", Value: sum("$stats.damage_done")},
+			{Key: "sum_kills", Value: sum("$stats.kills")},
+			{Key: "sum_deaths", Value: sum("$stats.deaths")},
+			{Key: "sum_heals", Value: sum("$stats.healed")},
+			{Key: "sum_playtime", Value: sum("$length")},
+			{Key: "count_games", Value: sum(1)},
+			{Key: "first_game", Value: bson.D{{Key: "$min", Value: "$date"}}},
+			{Key: "last_game", Value: bson.D{{Key: "$max", Value: "$date"}}},
+		}).
+		Sort(bson.D{{Key: "count_games", Value: -1}, {Key: "_id", Value: 1}}).
+		Build()
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	cur, err := c.Conn.
+		Database(c.database).
+		Collection(c.games).Aggregate(ctx, p, options.Aggregate())
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	var totals []classTotals
+	if err = cur.All(ctx, &totals); err != nil {
+		return nil, queryError(err)
+	}
+	if len(totals) == 0 {
+		return nil, ErrPlayerNotFound
+	}
+
+	player, _ := c.Players.Lookup(steamID)
+	return newProfile(steamID, player, totals), nil
+}
+
+// newProfile sums up per-class totals sorted by games played.
+func newProfile(steamID string, player Player, totals []classTotals) *Profile {
+	profile := &Profile{
+		PlayerName: player.Name,
+		Avatar:     player.Avatar,
+		SteamID64:  steamID,
+		Classes:    make([]ClassStats, 0, len(totals)),
+	}
+	for _, t := range totals {
+		profile.Games += t.Games
+		profile.Playtime += t.Playtime
+		if profile.FirstGame.IsZero() || t.FirstGame.Before(profile.FirstGame) {
+			profile.FirstGame = t.FirstGame
+		}
+		if t.LastGame.After(profile.LastGame) {
+			profile.LastGame = t.LastGame
+		}
+		profile.Classes = append(profile.Classes, t.stats())
+	}
+	return profile
+}
+
+// GetPlayerGames returns up to limit games of a player, newest first, starting after the cursor.
+// The returned cursor is nil when there are no more games. Malformed games are skipped and
+// reported in DataQuality, so a page may hold fewer games than limit and still have a cursor.
+func (c *Client) GetPlayerGames(ctx context.Context, steamID string, after *GameCursor, limit int) ([]Game, *GameCursor, error) {
+	filter := bson.D{{Key: "player.steam_id", Value: steamID}}
+	if after != nil {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "date", Value: bson.D{{Key: "$lt", Value: after.Date}}}},
+			bson.D{
+				{Key: "date", Value: after.Date},
+				{Key: "_id", Value: bson.D{{Key: "$lt", Value: after.ID}}},
+			},
+		}})
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit) + 1)
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	cur, err := c.Conn.
+		Database(c.database).
+		Collection(c.games).
+		Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, queryError(err)
+	}
+
+	defer cur.Close(ctx)
+
+	// pages are counted in documents read, so skipping a malformed game doesn't end the listing
+	games := make([]Game, 0, limit)
+	var last *GameCursor
+	read, more := 0, false
+	for cur.Next(ctx) {
+		if read == limit {
+			more = true
+			break
+		}
+		read++
+		if date, ok := cur.Current.Lookup("date").TimeOK(); ok {
+			if id, ok := cur.Current.Lookup("_id").ObjectIDOK(); ok {
+				last = &GameCursor{Date: date, ID: id}
+			}
+		}
+
+		var g Game
+		if err = cur.Decode(&g); err != nil {
+			c.quality.add(c.games, steamID, "game "+rawID(cur.Current.Lookup("_id"))+": "+err.Error())
+			continue
+		}
+		g.Class = g.Player.Class
+		games = append(games, g)
+	}
+	if err = cur.Err(); err != nil {
+		return nil, nil, queryError(err)
+	}
+
+	if !more {
+		return games, nil, nil
+	}
+	return games, last, nil
+}
+
+func (t classTotals) stats() ClassStats {
+	s := ClassStats{
+		Class:    t.Class,
+		Games:    t.Games,
+		Playtime: t.Playtime,
+	}
+	if t.Playtime > 0 {
+		minutes := float64(t.Playtime) / 60
+		if t.Class == "medic" {
+			hpm := round(float64(t.Heals)/minutes, 2)
+			s.HPM = &hpm
+		} else {
+			dpm := round(float64(t.Damage)/minutes, 2)
+			s.DPM = &dpm
+		}
+	}
+	if t.Deaths > 0 {
+		kdr := round(float64(t.Kills)/float64(t.Deaths), 1)
+		s.KDR = &kdr
+	}
+	return s
+}
+
+func round(v float64, precision int) float64 {
+	p := math.Pow(10, float64(precision))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// maxIssues bounds the number of malformed documents remembered.
+const maxIssues = 500
+
+// DocumentIssue describes a document that couldn't be read as expected.
+type DocumentIssue struct {
+	Collection string    `json:"collection"`
+	SteamID    string    `json:"steamid"`
+	Problem    string    `json:"problem"`
+	SeenAt     time.Time `json:"seen_at"`
+}
+
+// QualityReport lists malformed documents met while serving queries.
+// Total counts every issue, Issues keeps at most 500 of them.
+type QualityReport struct {
+	Issues []DocumentIssue `json:"issues"`
+	Total  int             `json:"total"`
+}
+
+// qualityLog keeps the latest issue of every malformed document, up to maxIssues of them,
+// and counts every malformed document per collection.
+type qualityLog struct {
+	mu     sync.Mutex
+	issues map[string]DocumentIssue
+	// seen lists malformed documents by SteamID per collection, including those over the cap.
+	seen map[string]map[string]bool
+}
+
+func newQualityLog() *qualityLog {
+	return &qualityLog{issues: make(map[string]DocumentIssue), seen: make(map[string]map[string]bool)}
+}
+
+func (l *qualityLog) add(collection, steamID, problem string) {
+	key := collection + "|" + steamID
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[collection] == nil {
+		l.seen[collection] = make(map[string]bool)
+	}
+	l.seen[collection][steamID] = true
+	if _, ok := l.issues[key]; !ok && len(l.issues) >= maxIssues {
+		return
+	}
+	l.issues[key] = DocumentIssue{Collection: collection, SteamID: steamID, Problem: problem, SeenAt: time.Now()}
+}
+
+// reset forgets issues of a collection before it is read again in full.
+func (l *qualityLog) reset(collection string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, issue := range l.issues {
+		if issue.Collection == collection {
+			delete(l.issues, key)
+		}
+	}
+	delete(l.seen, collection)
+}
+
+func (l *qualityLog) report() QualityReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := QualityReport{Issues: make([]DocumentIssue, 0, len(l.issues))}
+	for _, seen := range l.seen {
+		r.Total += len(seen)
+	}
+	for _, issue := range l.issues {
+		r.Issues = append(r.Issues, issue)
+	}
+	sort.Slice(r.Issues, func(i, j int) bool {
+		a, b := r.Issues[i], r.Issues[j]
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+		return a.SteamID < b.SteamID
+	})
+	return r
+}
+
+// ratingRow is a document produced by Metric.pipeline. Games may be stored
+// as any integer type; value is null when a player's games lack the fields.
+type ratingRow struct {
+	Value *float64 `bson:"value"`
+	Games int64    `bson:"games"`
+}
+
+// decodeResult reads a rating row. Rows with a problem are skipped.
+// Null values are a problem unless allowNull is set.
+func decodeResult(raw bson.Raw, metric string, allowNull bool) (r Result, problem string) {
+	r = Result{Metric: metric}
+	steamID, ok := SteamIDValue(raw.Lookup("_id"))
+	if !ok {
+		return r, "player id is not a SteamID64"
+	}
+	r.SteamID64 = steamID
+
+	var row ratingRow
+	if err := bson.Unmarshal(raw, &row); err != nil {
+		return r, err.Error()
+	}
+	if row.Value == nil && !allowNull {
+		return r, "value is missing"
+	}
+	r.Value = row.Value
+	r.Games = int32(row.Games)
+	return r, ""
+}
+
+// decodePlayer reads a names document, tolerating a missing avatar or one stored as a plain URL.
+// The player is skipped only when its SteamID can't be read; other problems are reported
+// along with what could be read.
+func decodePlayer(raw bson.Raw) (steamID string, p Player, problem string) {
+	steamID, ok := SteamIDValue(raw.Lookup("steam_id"))
+	if !ok {
+		return "", p, "steam_id is missing or not a SteamID64"
+	}
+
+	name := raw.Lookup("name")
+	if p.Name, ok = name.StringValueOK(); !ok {
+		problem = "name is missing or not a string"
+	}
+
+	avatar := raw.Lookup("avatar")
+	switch avatar.Type {
+	case bsontype.EmbeddedDocument:
+		small := avatar.Document().Lookup("small")
+		if p.Avatar, ok = small.StringValueOK(); !ok && problem == "" {
+			problem = "avatar.small is missing or not a string"
+		}
+	case bsontype.String:
+		p.Avatar = avatar.StringValue()
+	case bsontype.Null, 0:
+	default:
+		if problem == "" {
+			problem = "avatar has unexpected type " + avatar.Type.String()
+		}
+	}
+
+	if sites, ok := raw.Lookup("sites").ArrayOK(); ok {
+		values, _ := sites.Values()
+		for _, v := range values {
+			if site, ok := v.StringValueOK(); ok {
+				p.Sites = append(p.Sites, site)
+			}
+		}
+	}
+
+	// history holds every name seen by playerResolver, including the current one
+	if history, ok := raw.Lookup("history").ArrayOK(); ok {
+		values, _ := history.Values()
+		for _, v := range values {
+			record, ok := v.DocumentOK()
+			if !ok {
+				continue
+			}
+			name, ok := record.Lookup("name").StringValueOK()
+			if ok && name != p.Name && !containsString(p.Aliases, name) {
+				p.Aliases = append(p.Aliases, name)
+			}
+		}
+	}
+	return steamID, p, problem
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// rawID formats an id that couldn't be read for reports.
+func rawID(v bson.RawValue) string {
+	if v.Type == 0 {
+		return "(missing)"
+	}
+	return v.String()
+}
+
+// SteamIDValue reads a SteamID64 stored either as a string or as a number, as older tools did.
+func SteamIDValue(v bson.RawValue) (string, bool) {
+	switch v.Type {
+	case bsontype.String:
+		s := v.StringValue()
+		return s, s != ""
+	case bsontype.Int64:
+		return strconv.FormatInt(v.Int64(), 10), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func raw(t *testing.T, doc bson.D) bson.Raw {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodePlayer(t *testing.T) {
+	tests := []struct {
+		name        string
+		doc         bson.D
+		steamID     string
+		player      Player
+		wantProblem bool
+	}{
+		{"full", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A"},
+			{Key: "avatar", Value: bson.D{{Key: "small", Value: "a.jpg"}}},
+		}, "76561198000000001", Player{Name: "A", Avatar: "a.jpg"}, false},
+		{"sites", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A"},
+			{Key: "sites", Value: bson.A{"ru", "eu"}},
+		}, "76561198000000001", Player{Name: "A", Sites: []string{"ru", "eu"}}, false},
+		{"history", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A2"},
+			{Key: "history", Value: bson.A{
+				bson.D{{Key: "name", Value: "A"}},
+				bson.D{{Key: "name", Value: "A1"}, {Key: "avatar", Value: "a.jpg"}},
+				bson.D{{Key: "name", Value: "A"}},
+				bson.D{{Key: "name", Value: "A2"}},
+				"broken",
+			}},
+		}, "76561198000000001", Player{Name: "A2", Aliases: []string{"A", "A1"}}, false},
+		{"no avatar", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A"},
+		}, "76561198000000001", Player{Name: "A"}, false},
+		{"avatar url", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A"},
+			{Key: "avatar", Value: "a.jpg"},
+		}, "76561198000000001", Player{Name: "A", Avatar: "a.jpg"}, false},
+		{"numeric steamid", bson.D{
+			{Key: "steam_id", Value: int64(76561198000000001)},
+			{Key: "name", Value: "A"},
+		}, "76561198000000001", Player{Name: "A"}, false},
+		{"avatar without small", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: "A"},
+			{Key: "avatar", Value: bson.D{{Key: "large", Value: "a.jpg"}}},
+		}, "76561198000000001", Player{Name: "A"}, true},
+		{"name is a number", bson.D{
+			{Key: "steam_id", Value: "76561198000000001"},
+			{Key: "name", Value: 42},
+		}, "76561198000000001", Player{}, true},
+		{"no steamid", bson.D{{Key: "name", Value: "A"}}, "", Player{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steamID, player, problem := decodePlayer(raw(t, tt.doc))
+			if steamID != tt.steamID || !reflect.DeepEqual(player, tt.player) {
+				t.Errorf("got %q %+v, want %q %+v", steamID, player, tt.steamID, tt.player)
+			}
+			if (problem != "") != tt.wantProblem {
+				t.Errorf("got problem %q, want problem: %v", problem, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestDecodeResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		doc         bson.D
+		allowNull   bool
+		want        Result
+		wantProblem bool
+	}{
+		{"int32 games", bson.D{
+			{Key: "_id", Value: "76561198000000001"}, {Key: "value", Value: 250.5}, {Key: "games", Value: int32(12)},
+		}, false, Result{SteamID64: "76561198000000001", Metric: "dpm", Value: float(250.5), Games: 12}, false},
+		{"int64 games", bson.D{
+			{Key: "_id", Value: "76561198000000001"}, {Key: "value", Value: 250.5}, {Key: "games", Value: int64(12)},
+		}, false, Result{SteamID64: "76561198000000001", Metric: "dpm", Value: float(250.5), Games: 12}, false},
+		{"null value", bson.D{
+			{Key: "_id", Value: "76561198000000001"}, {Key: "value", Value: nil}, {Key: "games", Value: int32(12)},
+		}, false, Result{}, true},
+		{"null value allowed", bson.D{
+			{Key: "_id", Value: "76561198000000001"}, {Key: "value", Value: nil}, {Key: "games", Value: int32(12)},
+		}, true, Result{SteamID64: "76561198000000001", Metric: "dpm", Games: 12}, false},
+		{"null id", bson.D{
+			{Key: "_id", Value: nil}, {Key: "value", Value: 1.0}, {Key: "games", Value: int32(12)},
+		}, false, Result{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, problem := decodeResult(raw(t, tt.doc), "dpm", tt.allowNull)
+			if (problem != "") != tt.wantProblem {
+				t.Fatalf("got problem %q, want problem: %v", problem, tt.wantProblem)
+			}
+			if !tt.wantProblem && !reflect.DeepEqual(r, tt.want) {
+				t.Errorf("got %+v, want %+v", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestQualityLog(t *testing.T) {
+	l := newQualityLog()
+	l.add("names", "2", "name is missing")
+	l.add("names", "1", "name is missing")
+	l.add("names", "1", "avatar has unexpected type 32-bit integer")
+	l.add("games", "1", "dpm: value is missing")
+
+	r := l.report()
+	if r.Total != 3 || len(r.Issues) != 3 {
+		t.Fatalf("got %+v, want 3 issues", r)
+	}
+	if r.Issues[0].Collection != "games" || r.Issues[1].SteamID != "1" || r.Issues[1].Problem != "avatar has unexpected type 32-bit integer" {
+		t.Errorf("unexpected order or contents: %+v", r.Issues)
+	}
+
+	l.reset("names")
+	if r = l.report(); r.Total != 1 || len(r.Issues) != 1 {
+		t.Errorf("got %+v after reset, want only the games issue", r)
+	}
+}
+
+func TestQualityLogOverCap(t *testing.T) {
+	l := newQualityLog()
+	l.add("games", "g", "dpm: value is missing")
+	for refresh := 0; refresh < 3; refresh++ {
+		l.reset("names")
+		for i := 0; i < 600; i++ {
+			l.add("names", strconv.Itoa(i), "name is missing")
+		}
+		// ratings meet the same malformed row again
+		l.add("games", "g", "dpm: value is missing")
+
+		r := l.report()
+		if r.Total != 601 || len(r.Issues) != maxIssues {
+			t.Fatalf("refresh %d: got total %d with %d issues, want 601 with %d", refresh, r.Total, len(r.Issues), maxIssues)
+		}
+	}
+
+	l.reset("names")
+	if r := l.report(); r.Total != 1 || len(r.Issues) != 1 {
+		t.Errorf("got total %d with %d issues after reset, want only the games issue", r.Total, len(r.Issues))
+	}
+}
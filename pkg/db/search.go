@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// PlayerMatch is a player found by name. MatchedName differs from Name
+// when the player was found by a nickname they used before.
+type PlayerMatch struct {
+	SteamID64   string `json:"steamid64"`
+	Name        string `json:"name"`
+	Avatar      string `json:"avatar"`
+	MatchedName string `json:"matched_name"`
+}
+
+type scoredMatch struct {
+	PlayerMatch
+	score int
+}
+
+// searchPlayers finds up to limit players whose current or previous names start with,
+// contain or are a few typos away from q, ignoring case. Better matches come first,
+// matches of current names before those of previous ones.
+func searchPlayers(players map[string]Player, q string, limit int) []PlayerMatch {
+	q = strings.ToLower(strings.TrimSpace(q))
+	var found []scoredMatch
+	for steamID, p := range players {
+		best := -1
+		var matched string
+		names := append([]string{p.Name}, p.Aliases...)
+		for i, name := range names {
+			score, ok := nameScore(strings.ToLower(name), q)
+			if !ok {
+				continue
+			}
+			// an alias loses to the current name matching as well
+			score *= 2
+			if i > 0 {
+				score++
+			}
+			if best < 0 || score < best {
+				best, matched = score, name
+			}
+		}
+		if best >= 0 {
+			found = append(found, scoredMatch{
+				PlayerMatch: PlayerMatch{SteamID64: steamID, Name: p.Name, Avatar: p.Avatar, MatchedName: matched},
+				score:       best,
+			})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if a.Name != b.Name {
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+		return a.SteamID64 < b.SteamID64
+	})
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	matches := make([]PlayerMatch, len(found))
+	for i, m := range found {
+		matches[i] = m.PlayerMatch
+	}
+	return matches
+}
+
+// nameScore rates how well a lowercase name matches q: 0 for a prefix,
+// 1 for a substring and 1 + number of typos for a fuzzy prefix match.
+func nameScore(name, q string) (int, bool) {
+	switch {
+	case q == "":
+		return 0, false
+	case strings.HasPrefix(name, q):
+		return 0, true
+	case strings.Contains(name, q):
+		return 1, true
+	}
+
+	query := []rune(q)
+	typos := maxTypos(len(query))
+	if typos == 0 {
+		return 0, false
+	}
+	prefix := []rune(name)
+	if len(prefix) > len(query) {
+		prefix = prefix[:len(query)]
+	}
+	if d := editDistance(prefix, query); d <= typos {
+		return 1 + d, true
+	}
+	return 0, false
+}
+
+// maxTypos allows more typos in longer queries, none in short ones.
+func maxTypos(length int) int {
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Search finds players by current or previous names, see searchPlayers.
+func (d *PlayerDirectory) Search(q string, limit int) []PlayerMatch {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return searchPlayers(d.players, q, limit)
+}
+
+// SearchPlayers looks players up in the in-memory directory, it doesn't query MongoDB.
+func (c *Client) SearchPlayers(ctx context.Context, q string, limit int) ([]PlayerMatch, error) {
+	return c.Players.Search(q, limit), nil
+}
+
+func (s *MemoryStore) SearchPlayers(ctx context.Context, q string, limit int) ([]PlayerMatch, error) {
+	return searchPlayers(s.players, q, limit), nil
+}
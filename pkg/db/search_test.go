@@ -0,0 +1,45 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchPlayers(t *testing.T) {
+	players := map[string]Player{
+		"1": {Name: "Shadow", Avatar: "1.jpg"},
+		"2": {Name: "b4nny", Avatar: "2.jpg", Aliases: []string{"ShadowFan"}},
+		"3": {Name: "shady", Avatar: "3.jpg"},
+		"4": {Name: "Habib", Avatar: "4.jpg"},
+		"5": {Name: "xXshadowXx", Avatar: "5.jpg"},
+	}
+	tests := []struct {
+		q     string
+		limit int
+		want  []string
+	}{
+		{"sha", 0, []string{"1", "3", "2", "5"}},
+		{"SHADOW", 0, []string{"1", "2", "5"}},
+		{"shadow", 2, []string{"1", "2"}},
+		{"shadw", 0, []string{"1", "3", "2"}},
+		{"fan", 0, []string{"2"}},
+		{"hab", 0, []string{"4"}},
+		{"zzz", 0, nil},
+		{" ", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			var got []string
+			for _, m := range searchPlayers(players, tt.q, tt.limit) {
+				got = append(got, m.SteamID64)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if m := searchPlayers(players, "shadowf", 0)[0]; m.Name != "b4nny" || m.MatchedName != "ShadowFan" || m.Avatar != "2.jpg" {
+		t.Errorf("got %+v, want b4nny matched by ShadowFan", m)
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StatsStore covers every query the API needs. Queries are cancelled with ctx.
+// Client is the MongoDB implementation, MemoryStore keeps games in memory.
+type StatsStore interface {
+	GetRating(ctx context.Context, q RatingQuery) ([]Result, error)
+	GetPlayerProfile(ctx context.Context, steamID string) (*Profile, error)
+	GetPlayerGames(ctx context.Context, steamID string, after *GameCursor, limit int) ([]Game, *GameCursor, error)
+	GetGamesCount(ctx context.Context) (int64, error)
+	PlayersStatus() DirectoryStatus
+	// SearchPlayers finds up to limit players by current or previous names.
+	SearchPlayers(ctx context.Context, q string, limit int) ([]PlayerMatch, error)
+	// DataVersion changes whenever results of the queries above may change.
+	DataVersion(ctx context.Context) (string, error)
+	// Ping checks that the underlying database is reachable.
+	Ping(ctx context.Context) error
+	// DataQuality lists malformed documents met so far.
+	DataQuality() QualityReport
+}
+
+// Versioned is implemented by stores that can tell when their data last changed.
+type Versioned interface {
+	Version() (generation uint64, modified time.Time)
+}
+
+// changeWatcher is implemented by stores that can push change notifications.
+type changeWatcher interface {
+	watchChanges(ctx context.Context, changed chan<- struct{}, log logrus.FieldLogger)
+}
+
+var (
+	_ StatsStore    = (*Client)(nil)
+	_ StatsStore    = (*RatingCache)(nil)
+	_ StatsStore    = (*MemoryStore)(nil)
+	_ Versioned     = (*RatingCache)(nil)
+	_ changeWatcher = (*Client)(nil)
+)
+
+func (c *Client) PlayersStatus() DirectoryStatus {
+	return c.Players.Status()
+}
+
+func (c *Client) DataVersion(ctx context.Context) (string, error) {
+	count, err := c.GetGamesCount(ctx)
+	if err != nil {
+		return "", err
+	}
+	summarized, err := c.SummariesUpdatedAt(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d|%d|%d", count, summarized.UnixNano(), c.Players.version()), nil
+}
+
+func (c *Client) DataQuality() QualityReport {
+	return c.quality.report()
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Conn.Ping(ctx, nil)
+}
+
+func (c *Client) watchChanges(ctx context.Context, changed chan<- struct{}, log logrus.FieldLogger) {
+	if c.summaries != "" {
+		go c.watch(ctx, c.summaries, changed, log)
+	}
+	c.watch(ctx, c.games, changed, log)
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SummaryStateID is the _id of the document tracking summaries progress.
+const SummaryStateID = "summarizer"
+
+// SummaryBatchField marks games folded into summaries with the ID of their batch.
+// Games without it are yet to be summarized, whatever their _id.
+const SummaryBatchField = "summary_batch"
+
+// SummaryState tracks how far games have been folded into summaries.
+type SummaryState struct {
+	ID string `bson:"_id"`
+	// Batch is the last batch folded into summaries. It is zero for states
+	// written before games were marked, such summaries have to be rebuilt.
+	Batch primitive.ObjectID `bson:"batch"`
+	// Pending is a batch of marked games that is being merged. Summaries remember
+	// the last batch merged into them, so merging it again after a failure is safe.
+	Pending *primitive.ObjectID `bson:"pending,omitempty"`
+	// Fields are the SummaryFields summaries keep totals of.
+	Fields    []string  `bson:"fields"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// HasFields reports whether summaries keep totals of exactly the current SummaryFields.
+// Summaries built before a metric was added or changed lack some totals and have to be rebuilt.
+func (s SummaryState) HasFields() bool {
+	fields := SummaryFields()
+	if len(s.Fields) != len(fields) {
+		return false
+	}
+	for i, f := range fields {
+		if s.Fields[i] != f {
+			return false
+		}
+	}
+	return true
+}
+
+// SummaryStateCollection names the collection holding SummaryState of a summaries collection.
+func SummaryStateCollection(summaries string) string {
+	return summaries + "_state"
+}
+
+// SummaryKey names the sums field holding the total of a games field.
+func SummaryKey(field string) string {
+	return strings.ReplaceAll(field, ".", "_")
+}
+
+// SummaryFields lists the games fields summaries have to keep totals of,
+// which is every field used by a registered metric.
+func SummaryFields() []string {
+	seen := map[string]bool{"length": true}
+	for _, m := range metrics {
+		for _, f := range m.Numerator {
+			seen[f] = true
+		}
+		if m.Denominator != "" {
+			seen[m.Denominator] = true
+		}
+	}
+	fields := make([]string, 0, len(seen))
+	for f := range seen {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// summaryState returns nil if summaries are disabled or haven't been built yet.
+func (c *Client) summaryState(ctx context.Context) (*SummaryState, error) {
+	if c.summaries == "" {
+		return nil, nil
+	}
+	var state SummaryState
+	err := c.Conn.
+		Database(c.database).
+		Collection(SummaryStateCollection(c.summaries)).
+		FindOne(ctx, bson.D{{Key: "_id", Value: SummaryStateID}}).
+		Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// summariesUsable reports whether summaries are built with the current fields and no batch
+// is being merged into them.
+// Games not summarized yet are added by unsummarizedGames, so a stalled summarizer doesn't
+// serve stale ratings. While a batch is merged its games may be in summaries or not.
+func (c *Client) summariesUsable(ctx context.Context) (bool, error) {
+	state, err := c.summaryState(ctx)
+	if err != nil || state == nil || state.Batch.IsZero() || state.Pending != nil {
+		return false, err
+	}
+	return state.HasFields(), nil
+}
+
+// unsummarizedGames turns games without SummaryBatchField into one-game summary documents,
+// to be combined with summaries. The summary_batch index keeps it from scanning every game.
+func unsummarizedGames() mongo.Pipeline {
+	sums := bson.D{}
+	for _, f := range SummaryFields() {
+		sums = append(sums, bson.E{Key: SummaryKey(f), Value: "$" + f})
+	}
+	return NewPipeline().
+		Match(bson.D{{Key: SummaryBatchField, Value: nil}}).
+		Project(bson.D{
+			{Key: "steam_id", Value: "$player.steam_id"},
+			{Key: "class", Value: "$player.class"},
+			{Key: "games", Value: bson.D{{Key: "$literal", Value: 1}}},
+			{Key: "sums", Value: sums},
+		}).
+		Build()
+}
+
+// SummariesUpdatedAt returns when summaries were last updated,
+// zero time if they are disabled or not built.
+func (c *Client) SummariesUpdatedAt(ctx context.Context) (time.Time, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	state, err := c.summaryState(ctx)
+	if err != nil || state == nil {
+		return time.Time{}, queryError(err)
+	}
+	return state.UpdatedAt, nil
+}
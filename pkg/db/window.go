@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	ErrBadPeriod = errors.New("invalid period: must be 7d, 30d or season")
+	ErrBadDate   = errors.New("invalid date: must be YYYY-MM-DD or RFC3339")
+	ErrBadWindow = errors.New("invalid time window: from must be before to")
+	ErrPeriodMix = errors.New("period can't be combined with from or to")
+)
+
+// TimeWindow limits aggregations to games played in [From, To).
+// Zero bounds are left open.
+type TimeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w TimeWindow) IsZero() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+// filter matches games played inside the window.
+func (w TimeWindow) filter() bson.D {
+	if w.IsZero() {
+		return nil
+	}
+	date := bson.D{}
+	if !w.From.IsZero() {
+		date = append(date, bson.E{Key: "$gte", Value: w.From})
+	}
+	if !w.To.IsZero() {
+		date = append(date, bson.E{Key: "$lt", Value: w.To})
+	}
+	return bson.D{{Key: "date", Value: date}}
+}
+
+func (w TimeWindow) contains(t time.Time) bool {
+	return (w.From.IsZero() || !t.Before(w.From)) && (w.To.IsZero() || t.Before(w.To))
+}
+
+// ParseTimeWindow reads either the period shortcut (7d, 30d or season)
+// or explicit from/to bounds. Empty values leave the window open.
+func ParseTimeWindow(period, from, to string, now time.Time) (TimeWindow, error) {
+	if period != "" {
+		if from != "" || to != "" {
+			return TimeWindow{}, ErrPeriodMix
+		}
+		return periodWindow(period, now)
+	}
+
+	var (
+		window TimeWindow
+		err    error
+	)
+	if from != "" {
+		if window.From, err = parseDate(from, false); err != nil {
+			return TimeWindow{}, err
+		}
+	}
+	if to != "" {
+		if window.To, err = parseDate(to, true); err != nil {
+			return TimeWindow{}, err
+		}
+	}
+	if !window.From.IsZero() && !window.To.IsZero() && !window.From.Before(window.To) {
+		return TimeWindow{}, ErrBadWindow
+	}
+	return window, nil
+}
+
+// periodWindow converts a period shortcut into a window ending now.
+// A season is the current calendar quarter. Windows start on a full hour,
+// so the same period maps to the same cached rating for a while.
+func periodWindow(period string, now time.Time) (TimeWindow, error) {
+	now = now.UTC().Truncate(time.Hour)
+	switch period {
+	case "7d":
+		return TimeWindow{From: now.AddDate(0, 0, -7)}, nil
+	case "30d":
+		return TimeWindow{From: now.AddDate(0, 0, -30)}, nil
+	case "season":
+		firstMonth := time.Month((int(now.Month())-1)/3*3 + 1)
+		return TimeWindow{From: time.Date(now.Year(), firstMonth, 1, 0, 0, 0, 0, time.UTC)}, nil
+	default:
+		return TimeWindow{}, ErrBadPeriod
+	}
+}
+
+// parseDate accepts plain dates and RFC3339 timestamps. Plain dates used as
+// the upper bound include the whole day.
+func parseDate(raw string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, raw)
+	if err != nil {
+		return time.Time{}, ErrBadDate
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
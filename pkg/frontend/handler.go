@@ -1,12 +1,125 @@
 package frontend
 
-import "github.com/labstack/echo/v4"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
 
-func NewHandler(e *echo.Echo) {
-	e.Static("/src", "src/")
+	"PickupStats/pkg/db"
 
-	e.File("/", "src/html/average_kdr.html")
-	e.File("/kdr", "src/html/average_kdr.html")
-	e.File("/dpm", "src/html/average_dpm.html")
-	e.File("/hpm", "src/html/average_hpm.html")
+	"github.com/labstack/echo/v4"
+)
+
+// Options tune the frontend for a deployment.
+type Options struct {
+	// Dev disables caching, so files can be edited while the server runs.
+	Dev bool
+	// MinGames is used for ratings when the page doesn't set it.
+	MinGames int
+	// ZeroPolicy applies to ratios with a zero denominator, empty means db.ZeroSkip.
+	ZeroPolicy db.ZeroPolicy
+}
+
+type handler struct {
+	assets     fs.FS
+	store      db.StatsStore
+	dev        bool
+	minGames   int
+	zeroPolicy db.ZeroPolicy
+	// hashes holds content hashes of assets, computed once unless dev is set.
+	hashes map[string]string
+	// tmpl is parsed once unless dev is set.
+	tmpl *template.Template
+}
+
+// NewHandler serves server-rendered pages and assets from the given filesystem, e.g. src.FS.
+// Assets are served under /src/ with their content hash as ETag; links in pages carry
+// the hash too, so browsers can cache them forever. In dev mode nothing is cached.
+func NewHandler(e *echo.Echo, assets fs.FS, store db.StatsStore, opts Options) error {
+	h := &handler{assets: assets, store: store, dev: opts.Dev, minGames: opts.MinGames, zeroPolicy: opts.ZeroPolicy}
+	if !h.dev {
+		hashes, err := hashAll(assets)
+		if err != nil {
+			return err
+		}
+		h.hashes = hashes
+		if h.tmpl, err = h.parseTemplates(); err != nil {
+			return err
+		}
+	}
+
+	e.GET("/src/*", h.asset)
+	e.GET("/", h.rating("kdr"))
+	e.GET("/kdr", h.rating("kdr"))
+	e.GET("/dpm", h.rating("dpm"))
+	e.GET("/hpm", h.rating("hpm"))
+	e.GET("/ratings/:metric", h.ratingByParam)
+	e.GET("/players/:steamid", h.player)
+	return nil
+}
+
+func (h *handler) asset(ctx echo.Context) error {
+	name := path.Clean(ctx.Param("*"))
+	data, err := fs.ReadFile(h.assets, name)
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	hash := h.hash(name, data)
+
+	switch {
+	case h.dev:
+		ctx.Response().Header().Set("Cache-Control", "no-cache")
+	case ctx.QueryParam("v") == hash:
+		ctx.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		ctx.Response().Header().Set("Cache-Control", "public, no-cache")
+	}
+	return h.serve(ctx, name, hash, data)
+}
+
+// serve writes data with the hash as ETag, answering conditional requests with 304.
+func (h *handler) serve(ctx echo.Context, name, hash string, data []byte) error {
+	ctx.Response().Header().Set("ETag", `"`+hash+`"`)
+	http.ServeContent(ctx.Response(), ctx.Request(), name, time.Time{}, bytes.NewReader(data))
+	return nil
+}
+
+// hash returns the content hash of an asset. Data is read from assets when nil.
+func (h *handler) hash(name string, data []byte) string {
+	if !h.dev {
+		return h.hashes[name]
+	}
+	if data == nil {
+		var err error
+		if data, err = fs.ReadFile(h.assets, name); err != nil {
+			return ""
+		}
+	}
+	return contentHash(data)
+}
+
+func hashAll(assets fs.FS) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(assets, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+		hashes[name] = contentHash(data)
+		return nil
+	})
+	return hashes, err
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
 }
@@ -0,0 +1,251 @@
+package frontend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"PickupStats/pkg/db"
+	"PickupStats/pkg/steamid"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ratingPageSize = 50
+	gamesPageSize  = 20
+)
+
+type page struct {
+	Title      string
+	Active     string
+	GamesCount int64
+	Error      string
+}
+
+type ratingPage struct {
+	page
+	Metric   db.Metric
+	Metrics  []db.Metric
+	Classes  []string
+	Class    string
+	MinGames int
+	Period   string
+	Results  []db.Result
+	PrevURL  string
+	NextURL  string
+}
+
+type playerPage struct {
+	page
+	Profile *db.Profile
+	Games   []db.Game
+	NextURL string
+}
+
+func (h *handler) parseTemplates() (*template.Template, error) {
+	funcs := template.FuncMap{
+		"asset": func(name string) string {
+			link := "/src/" + name
+			if hash := h.hash(name, nil); hash != "" {
+				link += "?v=" + hash
+			}
+			return link
+		},
+		"title": func(s string) string {
+			if s == "" {
+				return s
+			}
+			return strings.ToUpper(s[:1]) + s[1:]
+		},
+		"minutes": func(seconds int64) int64 {
+			return seconds / 60
+		},
+		"date": func(t time.Time) string {
+			return t.Format("2006-01-02")
+		},
+	}
+	return template.New("").Funcs(funcs).ParseFS(h.assets, "templates/*.html")
+}
+
+func (h *handler) render(ctx echo.Context, status int, name string, data interface{}) error {
+	tmpl := h.tmpl
+	if h.dev {
+		var err error
+		if tmpl, err = h.parseTemplates(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	ctx.Response().Header().Set("Cache-Control", "no-cache")
+	return ctx.HTMLBlob(status, buf.Bytes())
+}
+
+func (h *handler) newPage(ctx echo.Context, title, active string) (page, error) {
+	count, err := h.store.GetGamesCount(ctx.Request().Context())
+	if err != nil {
+		return page{}, err
+	}
+	return page{Title: title, Active: active, GamesCount: count / 12}, nil
+}
+
+func (h *handler) ratingByParam(ctx echo.Context) error {
+	return h.rating(ctx.Param("metric"))(ctx)
+}
+
+// rating renders a leaderboard, filtered by class, mingames and period query parameters.
+func (h *handler) rating(metricName string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		metric, ok := db.LookupMetric(metricName)
+		if !ok {
+			return echo.ErrNotFound
+		}
+		base, err := h.newPage(ctx, metric.Title, metric.Name)
+		if err != nil {
+			return err
+		}
+		p := ratingPage{
+			page:    base,
+			Metric:  metric,
+			Metrics: db.Metrics(),
+			Classes: metric.Classes,
+			Class:   ctx.QueryParam("class"),
+			Period:  ctx.QueryParam("period"),
+		}
+		if len(p.Classes) == 0 {
+			p.Classes = db.Classes
+		}
+
+		q, pageNum, err := parseRatingQuery(ctx, metric, h.minGames)
+		q.ZeroPolicy = h.zeroPolicy
+		p.MinGames = q.MinGames
+		if err != nil {
+			p.Error = err.Error()
+			return h.render(ctx, http.StatusBadRequest, "rating.html", p)
+		}
+
+		results, err := h.store.GetRating(ctx.Request().Context(), q)
+		if err != nil {
+			return err
+		}
+		offset := (pageNum - 1) * ratingPageSize
+		if offset < len(results) {
+			p.Results = results[offset:]
+			if len(p.Results) > ratingPageSize {
+				p.Results = p.Results[:ratingPageSize]
+				p.NextURL = pageURL(ctx, "page", strconv.Itoa(pageNum+1))
+			}
+		}
+		if pageNum > 1 {
+			p.PrevURL = pageURL(ctx, "page", strconv.Itoa(pageNum-1))
+		}
+		return h.render(ctx, http.StatusOK, "rating.html", p)
+	}
+}
+
+func parseRatingQuery(ctx echo.Context, metric db.Metric, defaultMinGames int) (db.RatingQuery, int, error) {
+	q := db.RatingQuery{Metric: metric.Name, Class: ctx.QueryParam("class"), MinGames: defaultMinGames}
+	if q.Class != "" && (!db.ValidClass(q.Class) || !metric.AllowsClass(q.Class)) {
+		return q, 0, fmt.Errorf("%s isn't rated for class %q", metric.Title, q.Class)
+	}
+	if raw := ctx.QueryParam("mingames"); raw != "" {
+		minGames, err := strconv.Atoi(raw)
+		if err != nil || minGames < 0 {
+			return q, 0, errors.New("minimum games must be a non-negative number")
+		}
+		q.MinGames = minGames
+	}
+
+	var err error
+	q.Window, err = db.ParseTimeWindow(ctx.QueryParam("period"), ctx.QueryParam("from"), ctx.QueryParam("to"), time.Now())
+	if err != nil {
+		return q, 0, err
+	}
+
+	pageNum := 1
+	if raw := ctx.QueryParam("page"); raw != "" {
+		pageNum, err = strconv.Atoi(raw)
+		if err != nil || pageNum < 1 {
+			return q, 0, errors.New("page must be a positive number")
+		}
+	}
+	return q, pageNum, nil
+}
+
+// player renders a player's profile with their recent games.
+// Other Steam ID formats are redirected to the SteamID64 address.
+func (h *handler) player(ctx echo.Context) error {
+	raw, err := url.PathUnescape(ctx.Param("steamid"))
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	id, err := steamid.Parse(raw)
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	steamID := id.String()
+	if raw != steamID {
+		target := "/players/" + steamID
+		if q := ctx.Request().URL.RawQuery; q != "" {
+			target += "?" + q
+		}
+		return ctx.Redirect(http.StatusMovedPermanently, target)
+	}
+	base, err := h.newPage(ctx, "Player "+steamID, "")
+	if err != nil {
+		return err
+	}
+	p := playerPage{page: base}
+
+	profile, err := h.store.GetPlayerProfile(ctx.Request().Context(), steamID)
+	if errors.Is(err, db.ErrPlayerNotFound) {
+		p.Error = "This player has no games yet."
+		return h.render(ctx, http.StatusNotFound, "player.html", p)
+	}
+	if err != nil {
+		return err
+	}
+	p.Profile = profile
+	if profile.PlayerName != "" {
+		p.Title = profile.PlayerName
+	}
+
+	var after *db.GameCursor
+	if raw := ctx.QueryParam("cursor"); raw != "" {
+		cursor, err := db.ParseGameCursor(raw)
+		if err != nil {
+			p.Error = err.Error()
+			return h.render(ctx, http.StatusBadRequest, "player.html", p)
+		}
+		after = &cursor
+	}
+	games, next, err := h.store.GetPlayerGames(ctx.Request().Context(), steamID, after, gamesPageSize)
+	if err != nil {
+		return err
+	}
+	p.Games = games
+	if next != nil {
+		p.NextURL = pageURL(ctx, "cursor", next.String())
+	}
+	return h.render(ctx, http.StatusOK, "player.html", p)
+}
+
+// pageURL links to the current page with one query parameter replaced.
+func pageURL(ctx echo.Context, key, value string) string {
+	query := url.Values{}
+	for k, v := range ctx.QueryParams() {
+		query[k] = v
+	}
+	query.Set(key, value)
+	return ctx.Request().URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,121 @@
+package frontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"PickupStats/pkg/db"
+	"PickupStats/src"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	scout = "76561198000000001"
+	medic = "76561198000000002"
+)
+
+func newTestServer(t *testing.T) *echo.Echo {
+	var games []db.Game
+	for i := 0; i < 25; i++ {
+		games = append(games,
+			db.Game{
+				ID:     primitive.NewObjectID(),
+				LogID:  int64(3000000 + i),
+				Date:   time.Date(2021, 10, 1+i, 20, 0, 0, 0, time.UTC),
+				Length: 600,
+				Player: db.GamePlayer{SteamID: scout, Class: "scout"},
+				Stats:  db.GameStats{DamageDone: 3000, Kills: 10, Deaths: 5},
+			},
+			db.Game{
+				ID:     primitive.NewObjectID(),
+				LogID:  int64(3000000 + i),
+				Date:   time.Date(2021, 10, 1+i, 20, 0, 0, 0, time.UTC),
+				Length: 600,
+				Player: db.GamePlayer{SteamID: medic, Class: "medic"},
+				Stats:  db.GameStats{Healed: 12000, Deaths: 2},
+			},
+		)
+	}
+	store := db.NewMemoryStore(games, map[string]db.Player{
+		scout: {Name: "<script>scout</script>", Avatar: "a.jpg"},
+	})
+
+	e := echo.New()
+	if err := NewHandler(e, src.FS, store, Options{MinGames: 10}); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		contains []string
+	}{
+		{"index", "/", http.StatusOK, []string{`href="/players/` + scout + `"`, "&lt;script&gt;scout&lt;/script&gt;"}},
+		{"dpm", "/dpm?class=scout&mingames=5", http.StatusOK, []string{"<td>300</td>", `value="scout" selected`}},
+		{"hpm", "/hpm", http.StatusOK, []string{`href="/players/` + medic + `"`, "<td>1200</td>"}},
+		{"any metric", "/ratings/kad?period=season", http.StatusOK, []string{"KA/D", `value="season" selected`}},
+		{"unknown metric", "/ratings/fun", http.StatusNotFound, nil},
+		{"bad class", "/hpm?class=scout", http.StatusBadRequest, []string{"alert"}},
+		{"bad min games", "/dpm?mingames=-1", http.StatusBadRequest, []string{"alert"}},
+		{"player", "/players/" + scout, http.StatusOK, []string{"https://logs.tf/3000024", "Older games", "steamcommunity.com/profiles/" + scout}},
+		{"player by steamid3", "/players/%5BU:1:39734273%5D", http.StatusMovedPermanently, nil},
+		{"player bad steamid", "/players/abc", http.StatusNotFound, nil},
+		{"player not found", "/players/76561198000000009", http.StatusNotFound, []string{"no games"}},
+		{"player bad cursor", "/players/" + scout + "?cursor=nope", http.StatusBadRequest, []string{"alert"}},
+		{"asset", "/src/css/styles.css", http.StatusOK, nil},
+		{"missing asset", "/src/css/nope.css", http.StatusNotFound, nil},
+	}
+
+	e := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(rec.Body.String(), s) {
+					t.Errorf("page doesn't contain %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestAssetCaching(t *testing.T) {
+	e := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dpm", nil))
+	start := strings.Index(rec.Body.String(), "/src/css/styles.css?v=")
+	if start < 0 {
+		t.Fatal("page doesn't link styles with a content hash")
+	}
+	link := rec.Body.String()[start:]
+	link = link[:strings.Index(link, `"`)]
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link, nil))
+	if got := rec.Header().Get("Cache-Control"); !strings.Contains(got, "immutable") {
+		t.Errorf("got Cache-Control %q for hashed link, want immutable", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/src/css/styles.css", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", rec.Code)
+	}
+}
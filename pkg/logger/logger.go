@@ -1,12 +1,15 @@
 package logger
 
 import (
+	"fmt"
+
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	echologrus "github.com/spirosoik/echo-logrus"
 )
 
-func SetLogger(e *echo.Echo, lvl string) (*logrus.Logger, error) {
+// SetLogger installs a logrus logger with the given level and format, text or json, into echo.
+func SetLogger(e *echo.Echo, lvl, format string) (*logrus.Logger, error) {
 	logger := logrus.New()
 
 	level, err := logrus.ParseLevel(lvl)
@@ -14,6 +17,14 @@ func SetLogger(e *echo.Echo, lvl string) (*logrus.Logger, error) {
 		return logger, err
 	}
 
+	switch format {
+	case "", "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return logger, fmt.Errorf("unknown log format %q", format)
+	}
+
 	logger.SetLevel(level)
 	mw := echologrus.NewLoggerMiddleware(logger)
 	e.Logger = mw
@@ -0,0 +1,98 @@
+package monitoring
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pickupstats"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// AggregationDuration observes rating aggregations by metric and source collection, games or summaries.
+	AggregationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_aggregation_duration_seconds",
+		Help:      "Duration of rating aggregations in MongoDB by metric and source.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"metric", "source"})
+
+	// CacheRequests counts rating cache lookups by result, hit or miss.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rating_cache_requests_total",
+		Help:      "Rating cache lookups by result, hit or miss.",
+	}, []string{"result"})
+)
+
+// Middleware records count and latency of requests per route.
+// Routes are echo paths, e.g. /api/ratings/:metric, to keep the number of series bounded.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			start := time.Now()
+			if err := next(ctx); err != nil {
+				ctx.Error(err)
+			}
+
+			route := ctx.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := ctx.Request().Method
+			status := strconv.Itoa(ctx.Response().Status)
+			requests.WithLabelValues(route, method, status).Inc()
+			requestDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// Gauges are read on every scrape.
+type Gauges struct {
+	PlayersDirectorySize func() float64
+	GamesCount           func() (float64, error)
+}
+
+// RegisterGauges exposes state of the stats store. Failed reads are reported as -1.
+func RegisterGauges(g Gauges) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "players_directory_size",
+		Help:      "Number of players in the in-memory names directory.",
+	}, g.PlayersDirectorySize)
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "games_count",
+		Help:      "Number of player records in the games collection.",
+	}, func() float64 {
+		count, err := g.GamesCount()
+		if err != nil {
+			return -1
+		}
+		return count
+	})
+}
+
+// Handler serves collected metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,39 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware())
+	e.GET("/api/ratings/:metric", func(ctx echo.Context) error {
+		if ctx.Param("metric") == "fun" {
+			return echo.ErrNotFound
+		}
+		return ctx.NoContent(http.StatusOK)
+	})
+	e.GET("/metrics", echo.WrapHandler(Handler()))
+
+	for _, target := range []string{"/api/ratings/dpm", "/api/ratings/kdr", "/api/ratings/fun"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`pickupstats_http_requests_total{method="GET",route="/api/ratings/:metric",status="200"} 2`,
+		`pickupstats_http_requests_total{method="GET",route="/api/ratings/:metric",status="404"} 1`,
+		`pickupstats_http_request_duration_seconds_count{method="GET",route="/api/ratings/:metric"} 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %s", want)
+		}
+	}
+}
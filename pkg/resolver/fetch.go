@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"PickupStats/pkg/config"
+)
+
+// maxBackoff caps the delay between retries.
+const maxBackoff = time.Minute
+
+// ErrNoSites stops a sync that would flag every stored player as deleted.
+var ErrNoSites = errors.New("no sites configured")
+
+type PickupPlayer struct {
+	SteamId string `json:"steamId" bson:"steam_id"`
+	Name    string `json:"name" bson:"name"`
+	Avatar  struct {
+		Small  string `json:"small" bson:"small"`
+		Medium string `json:"medium" bson:"medium"`
+		Large  string `json:"large" bson:"large"`
+	} `json:"avatar" bson:"avatar"`
+	Roles          []string  `json:"roles" bson:"roles"`
+	Etf2LProfileId int       `json:"etf2lProfileId" bson:"etf2l_profile_id"`
+	JoinedAt       time.Time `json:"joinedAt" bson:"joined_at"`
+	Id             string    `json:"id" bson:"id"`
+	Links          []struct {
+		Href  string `json:"href" bson:"href"`
+		Title string `json:"title" bson:"title"`
+	} `json:"_links" bson:"links"`
+	// Sites lists names of configured sites the player is on.
+	Sites []string `json:"-" bson:"sites"`
+}
+
+// statusError is an unexpected HTTP status of a tf2pickup API.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("api returned http code: %d", e.code)
+}
+
+// Fetcher reads players from tf2pickup APIs, retrying failed requests.
+type Fetcher struct {
+	Client *http.Client
+	// Retries is the number of attempts after the first failed one.
+	Retries int
+	// Backoff is the delay before the first retry, doubled for every next one.
+	Backoff time.Duration
+}
+
+// FetchAll reads players of every site and merges them with MergeSites.
+// It fails if any site can't be read, since a partial list would flag the site's players as deleted.
+func (f Fetcher) FetchAll(ctx context.Context, sites []config.Site) ([]PickupPlayer, error) {
+	if len(sites) == 0 {
+		return nil, ErrNoSites
+	}
+	perSite := make([][]PickupPlayer, 0, len(sites))
+	for _, site := range sites {
+		players, err := f.FetchPlayers(ctx, site)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get players from %s: %w", site.Name, err)
+		}
+		perSite = append(perSite, players)
+	}
+	return MergeSites(perSite), nil
+}
+
+// FetchPlayers reads all players of a tf2pickup site and tags them with the site name.
+// Failures other than 4xx responses, except 429, are retried with exponential backoff.
+func (f Fetcher) FetchPlayers(ctx context.Context, site config.Site) ([]PickupPlayer, error) {
+	backoff := f.Backoff
+	for attempt := 0; ; attempt++ {
+		players, err := f.getPlayers(ctx, site)
+		if err == nil || attempt >= f.Retries || !retryable(err) || ctx.Err() != nil {
+			return players, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (f Fetcher) getPlayers(ctx context.Context, site config.Site) ([]PickupPlayer, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(site.URL, "/")+"/players", nil)
+	if err != nil {
+		return nil, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+
+	players := make([]PickupPlayer, 0)
+	if err = json.NewDecoder(resp.Body).Decode(&players); err != nil {
+		return nil, err
+	}
+	for i := range players {
+		players[i].Sites = []string{site.Name}
+	}
+	return players, nil
+}
+
+// retryable tells errors worth another attempt: anything but a 4xx status other than 429.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= http.StatusInternalServerError || status.code == http.StatusTooManyRequests
+	}
+	return true
+}
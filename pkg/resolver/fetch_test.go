@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"PickupStats/pkg/config"
+)
+
+func TestFetchPlayersRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		status    int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"ok", 0, http.StatusOK, 1, false},
+		{"recovers", 2, http.StatusServiceUnavailable, 3, false},
+		{"rate limited", 1, http.StatusTooManyRequests, 2, false},
+		{"gives up", 5, http.StatusBadGateway, 3, true},
+		{"not found", 1, http.StatusNotFound, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if r.URL.Path != "/players" {
+					t.Errorf("got path %s", r.URL.Path)
+				}
+				if calls <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				_, _ = w.Write([]byte(`[{"steamId": "1", "name": "A"}]`))
+			}))
+			defer srv.Close()
+
+			f := Fetcher{Retries: 2}
+			players, err := f.FetchPlayers(context.Background(), config.Site{Name: "ru", URL: srv.URL + "/"})
+			if (err != nil) != tt.wantErr || calls != tt.wantCalls {
+				t.Fatalf("got error %v after %d calls, want error %t after %d", err, calls, tt.wantErr, tt.wantCalls)
+			}
+			if !tt.wantErr && (len(players) != 1 || players[0].Sites[0] != "ru") {
+				t.Errorf("got players %+v, want A tagged with ru", players)
+			}
+		})
+	}
+}
+
+func TestFetchAllWithoutSites(t *testing.T) {
+	if _, err := (Fetcher{}).FetchAll(context.Background(), nil); err != ErrNoSites {
+		t.Errorf("got %v, want ErrNoSites", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Package resolver keeps the names collection in line with players of tf2pickup sites.
+// It is run by playerResolver once and by the API server on a schedule.
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"PickupStats/pkg/config"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Status describes the last sync.
+type Status struct {
+	// IntervalSeconds between syncs, zero when the server doesn't sync players.
+	IntervalSeconds float64 `json:"interval_seconds"`
+	// LastSync is when the last sync finished, successfully or not.
+	LastSync    time.Time `json:"last_sync"`
+	LastSuccess time.Time `json:"last_success"`
+	// Error of the last sync, empty when it succeeded.
+	Error   string   `json:"error,omitempty"`
+	Summary *Summary `json:"summary,omitempty"`
+}
+
+// Resolver syncs players of the sites into the names collection.
+type Resolver struct {
+	names   *mongo.Collection
+	sites   []config.Site
+	fetcher Fetcher
+
+	mu     sync.RWMutex
+	status Status
+}
+
+func New(names *mongo.Collection, sites []config.Site, fetcher Fetcher) *Resolver {
+	return &Resolver{names: names, sites: sites, fetcher: fetcher}
+}
+
+// SyncOnce fetches players of every site and writes the changes, unless dryRun is set.
+// Only real syncs are recorded in Status.
+func (r *Resolver) SyncOnce(ctx context.Context, dryRun bool, log logrus.FieldLogger) (Summary, error) {
+	summary, err := r.sync(ctx, dryRun, log)
+	if dryRun {
+		return summary, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.LastSync = time.Now()
+	if err != nil {
+		r.status.Error = err.Error()
+		return summary, err
+	}
+	r.status.LastSuccess, r.status.Error, r.status.Summary = r.status.LastSync, "", &summary
+	return summary, nil
+}
+
+func (r *Resolver) sync(ctx context.Context, dryRun bool, log logrus.FieldLogger) (Summary, error) {
+	players, err := r.fetcher.FetchAll(ctx, r.sites)
+	if err != nil {
+		return Summary{}, err
+	}
+	log.Infof("Got %d players from %d sites", len(players), len(r.sites))
+	return Sync(ctx, r.names, players, dryRun, log)
+}
+
+// Status returns the outcome of the last sync.
+func (r *Resolver) Status() Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := r.status
+	if s.Summary != nil {
+		summary := *s.Summary
+		s.Summary = &summary
+	}
+	return s
+}
+
+// Run syncs players right away and then every interval until ctx is done.
+func (r *Resolver) Run(ctx context.Context, interval time.Duration, log logrus.FieldLogger) {
+	r.mu.Lock()
+	r.status.IntervalSeconds = interval.Seconds()
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		summary, err := r.SyncOnce(ctx, false, log)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Errorf("Failed to sync players: %v", err)
+		case err == nil:
+			log.Infof("Synced players: %s", summary)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"PickupStats/pkg/db"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StoredPlayer is the part of a names document compared against upstream.
+type StoredPlayer struct {
+	ID      interface{} `bson:"_id"`
+	SteamID string      `bson:"steam_id"`
+	Name    string      `bson:"name"`
+	Avatar  struct {
+		Small  string `bson:"small"`
+		Medium string `bson:"medium"`
+		Large  string `bson:"large"`
+	} `bson:"avatar"`
+	Sites   []string     `bson:"sites"`
+	History []NameRecord `bson:"history"`
+	// DeletedAt is set when the player disappeared from upstream.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty"`
+}
+
+// NameRecord is an entry of a player's history, searched by the API for old nicknames.
+type NameRecord struct {
+	Name   string `bson:"name"`
+	Avatar string `bson:"avatar"`
+	// SeenAt is when the name was first resolved, unset for names stored before history was kept.
+	SeenAt *time.Time `bson:"seen_at,omitempty"`
+}
+
+// Plan lists writes that bring the names collection in line with upstream.
+type Plan struct {
+	Inserts   []PickupPlayer
+	Updates   []PickupPlayer
+	Deletes   []string
+	Unchanged int
+	// Changes describe every update for the log.
+	Changes []string
+	// Recorded are SteamIDs of players whose current name and avatar are added to history.
+	Recorded map[string]bool
+	// Backfill keeps stored names of players changed before their history was kept.
+	Backfill map[string]NameRecord
+	// Duplicates are _ids of extra documents of a SteamID, left by older versions of the tool.
+	// They are removed before the unique steam_id index is created.
+	Duplicates []interface{}
+}
+
+// Summary counts what a sync did, or would do in a dry run.
+// Failed writes are also counted in the group they were planned for.
+type Summary struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Flagged   int `json:"flagged"`
+	Failed    int `json:"failed"`
+	// Removed counts duplicate documents deleted.
+	Removed int `json:"removed"`
+	// Skipped counts documents left alone because their steam_id can't be read.
+	Skipped int `json:"skipped"`
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d inserted, %d updated, %d unchanged, %d flagged as deleted, %d duplicates removed, %d skipped, %d failed",
+		s.Inserted, s.Updated, s.Unchanged, s.Flagged, s.Removed, s.Skipped, s.Failed)
+}
+
+// MergeSites combines players of several sites into one per SteamID, listing every
+// site the player is on. Name and avatar come from the first site, in config order.
+func MergeSites(perSite [][]PickupPlayer) []PickupPlayer {
+	var merged []PickupPlayer
+	index := make(map[string]int)
+	for _, players := range perSite {
+		for _, player := range players {
+			i, ok := index[player.SteamId]
+			if !ok {
+				index[player.SteamId] = len(merged)
+				merged = append(merged, player)
+				continue
+			}
+			for _, site := range player.Sites {
+				if !contains(merged[i].Sites, site) {
+					merged[i].Sites = append(merged[i].Sites, site)
+				}
+			}
+		}
+	}
+	return merged
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPlan compares upstream players with stored ones by SteamID. Players missing
+// upstream are flagged as deleted rather than removed, so old games keep their names.
+func NewPlan(upstream []PickupPlayer, stored map[string]StoredPlayer) Plan {
+	p := Plan{Recorded: make(map[string]bool), Backfill: make(map[string]NameRecord)}
+	seen := make(map[string]bool, len(upstream))
+	for _, player := range upstream {
+		if player.SteamId == "" || seen[player.SteamId] {
+			continue
+		}
+		seen[player.SteamId] = true
+
+		old, ok := stored[player.SteamId]
+		if !ok {
+			p.Inserts = append(p.Inserts, player)
+			p.Recorded[player.SteamId] = true
+			continue
+		}
+		changes := diffPlayer(old, player)
+		if len(changes) == 0 {
+			p.Unchanged++
+			continue
+		}
+		p.Updates = append(p.Updates, player)
+		if old.Name != player.Name || old.Avatar.Small != player.Avatar.Small {
+			p.Recorded[player.SteamId] = true
+			if len(old.History) == 0 {
+				p.Backfill[player.SteamId] = NameRecord{Name: old.Name, Avatar: old.Avatar.Small}
+			}
+		}
+		for _, c := range changes {
+			p.Changes = append(p.Changes, player.SteamId+": "+c)
+		}
+	}
+	for steamID, old := range stored {
+		if !seen[steamID] && old.DeletedAt == nil {
+			p.Deletes = append(p.Deletes, steamID)
+		}
+	}
+	sort.Strings(p.Deletes)
+	return p
+}
+
+func diffPlayer(old StoredPlayer, player PickupPlayer) []string {
+	var changes []string
+	if old.Name != player.Name {
+		changes = append(changes, fmt.Sprintf("renamed %q to %q", old.Name, player.Name))
+	}
+	if old.Avatar.Small != player.Avatar.Small || old.Avatar.Medium != player.Avatar.Medium || old.Avatar.Large != player.Avatar.Large {
+		changes = append(changes, "avatar changed")
+	}
+	if strings.Join(old.Sites, ",") != strings.Join(player.Sites, ",") {
+		changes = append(changes, fmt.Sprintf("sites changed from %v to %v", old.Sites, player.Sites))
+	}
+	if old.DeletedAt != nil {
+		changes = append(changes, "returned upstream")
+	}
+	return changes
+}
+
+// Summary is what applying the plan would do.
+func (p Plan) Summary() Summary {
+	return Summary{Inserted: len(p.Inserts), Updated: len(p.Updates), Unchanged: p.Unchanged, Flagged: len(p.Deletes), Removed: len(p.Duplicates)}
+}
+
+// models turns the plan into upserts keyed by steam_id, safe to apply more than once.
+// New names and avatars are pushed to history as seen at now.
+func (p Plan) models(now time.Time) []mongo.WriteModel {
+	var models []mongo.WriteModel
+	upsert := func(player PickupPlayer) {
+		update := bson.D{
+			{Key: "$set", Value: player},
+			{Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}},
+		}
+		if records := p.history(player, now); len(records) > 0 {
+			update = append(update, bson.E{Key: "$push", Value: bson.D{
+				{Key: "history", Value: bson.D{{Key: "$each", Value: records}}},
+			}})
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "steam_id", Value: player.SteamId}}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	for _, player := range p.Inserts {
+		upsert(player)
+	}
+	for _, player := range p.Updates {
+		upsert(player)
+	}
+	for _, steamID := range p.Deletes {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "steam_id", Value: steamID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: now}}}}))
+	}
+	return models
+}
+
+// history lists records appended to the player's history, oldest first.
+func (p Plan) history(player PickupPlayer, now time.Time) []NameRecord {
+	var records []NameRecord
+	if old, ok := p.Backfill[player.SteamId]; ok {
+		records = append(records, old)
+	}
+	if p.Recorded[player.SteamId] {
+		records = append(records, NameRecord{Name: player.Name, Avatar: player.Avatar.Small, SeenAt: &now})
+	}
+	return records
+}
+
+// loadStored reads stored players in _id order, see dedupe. Documents without a readable
+// steam_id are left out and described in malformed, they are neither synced nor removed.
+func loadStored(ctx context.Context, names *mongo.Collection) (players []StoredPlayer, malformed []string, err error) {
+	cur, err := names.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		p, ok := decodeStored(cur.Current)
+		if !ok {
+			malformed = append(malformed, fmt.Sprintf("document %s has no readable steam_id", cur.Current.Lookup("_id")))
+			continue
+		}
+		players = append(players, p)
+	}
+	return players, malformed, cur.Err()
+}
+
+// decodeStored reads a names document with the rules of db.PlayerNames: steam_id may be
+// a number and avatar a plain URL. Other unreadable fields are left empty, so the plan
+// rewrites them from upstream. It fails only when steam_id can't be read.
+func decodeStored(raw bson.Raw) (StoredPlayer, bool) {
+	var p StoredPlayer
+	steamID, ok := db.SteamIDValue(raw.Lookup("steam_id"))
+	if !ok {
+		return p, false
+	}
+	p.ID, p.SteamID = raw.Lookup("_id"), steamID
+	p.Name, _ = raw.Lookup("name").StringValueOK()
+
+	avatar := raw.Lookup("avatar")
+	if doc, ok := avatar.DocumentOK(); ok {
+		p.Avatar.Small, _ = doc.Lookup("small").StringValueOK()
+		p.Avatar.Medium, _ = doc.Lookup("medium").StringValueOK()
+		p.Avatar.Large, _ = doc.Lookup("large").StringValueOK()
+	} else if url, ok := avatar.StringValueOK(); ok {
+		p.Avatar.Small, p.Avatar.Medium, p.Avatar.Large = url, url, url
+	}
+
+	if sites, ok := raw.Lookup("sites").ArrayOK(); ok {
+		values, _ := sites.Values()
+		for _, v := range values {
+			if site, ok := v.StringValueOK(); ok {
+				p.Sites = append(p.Sites, site)
+			}
+		}
+	}
+	if history, ok := raw.Lookup("history").ArrayOK(); ok {
+		values, _ := history.Values()
+		for _, v := range values {
+			var record NameRecord
+			if doc, ok := v.DocumentOK(); ok && bson.Unmarshal(doc, &record) == nil {
+				p.History = append(p.History, record)
+			}
+		}
+	}
+	if deletedAt, ok := raw.Lookup("deleted_at").TimeOK(); ok {
+		p.DeletedAt = &deletedAt
+	}
+	return p, true
+}
+
+// dedupe keeps the last of players sharing a SteamID, which is the newest one when they
+// are sorted by ObjectID, and returns _ids of the others. Players without a SteamID are ignored.
+func dedupe(players []StoredPlayer) (map[string]StoredPlayer, []interface{}) {
+	stored := make(map[string]StoredPlayer, len(players))
+	var duplicates []interface{}
+	for _, p := range players {
+		if p.SteamID == "" {
+			continue
+		}
+		if old, ok := stored[p.SteamID]; ok {
+			duplicates = append(duplicates, old.ID)
+		}
+		stored[p.SteamID] = p
+	}
+	return stored, duplicates
+}
+
+// Sync brings the names collection in line with upstream players. Writes are unordered,
+// so a failed one doesn't stop the others; failures are counted in the summary.
+func Sync(ctx context.Context, names *mongo.Collection, upstream []PickupPlayer, dryRun bool, log logrus.FieldLogger) (Summary, error) {
+	players, malformed, err := loadStored(ctx, names)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to load stored players: %w", err)
+	}
+	for _, m := range malformed {
+		log.Warn("Skipped " + m)
+	}
+	stored, duplicates := dedupe(players)
+	plan := NewPlan(upstream, stored)
+	plan.Duplicates = duplicates
+	for _, c := range plan.Changes {
+		log.Info(c)
+	}
+	for _, steamID := range plan.Deletes {
+		log.Info(steamID + ": gone upstream")
+	}
+
+	summary := plan.Summary()
+	summary.Skipped = len(malformed)
+	models := plan.models(time.Now())
+	if dryRun || (len(models) == 0 && len(duplicates) == 0) {
+		return summary, nil
+	}
+
+	if len(duplicates) > 0 {
+		if _, err = names.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: duplicates}}}}); err != nil {
+			return Summary{}, fmt.Errorf("failed to remove duplicate players: %w", err)
+		}
+		log.Infof("Removed %d duplicate players", len(duplicates))
+	}
+	// upserts by steam_id would create duplicates again without it. Documents skipped for
+	// lacking a steam_id all index as null, so only string ones, which syncs write, are covered.
+	if _, err = names.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "steam_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+			bson.D{{Key: "steam_id", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+	}); err != nil {
+		return Summary{}, fmt.Errorf("failed to create unique steam_id index: %w", err)
+	}
+
+	_, err = names.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, e := range bulkErr.WriteErrors {
+			log.Errorf("Failed to write player #%d: %v", e.Index, e.Message)
+		}
+		summary.Failed = len(bulkErr.WriteErrors)
+		return summary, nil
+	}
+	return summary, err
+}
@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func pickupPlayer(steamID, name, avatar string) PickupPlayer {
+	var p PickupPlayer
+	p.SteamId, p.Name = steamID, name
+	p.Avatar.Small, p.Avatar.Medium, p.Avatar.Large = avatar, avatar, avatar
+	return p
+}
+
+func storedPlayer(steamID, name, avatar string) StoredPlayer {
+	var p StoredPlayer
+	p.SteamID, p.Name = steamID, name
+	p.Avatar.Small, p.Avatar.Medium, p.Avatar.Large = avatar, avatar, avatar
+	return p
+}
+
+func TestNewPlan(t *testing.T) {
+	deletedAt := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	returning := storedPlayer("5", "E", "e.jpg")
+	returning.DeletedAt = &deletedAt
+	gone := storedPlayer("7", "G", "g.jpg")
+	gone.DeletedAt = &deletedAt
+
+	stored := map[string]StoredPlayer{
+		"1": storedPlayer("1", "A", "a.jpg"),
+		"2": storedPlayer("2", "B", "b.jpg"),
+		"3": storedPlayer("3", "C", "c.jpg"),
+		"5": returning,
+		"6": storedPlayer("6", "F", "f.jpg"),
+		"7": gone,
+	}
+	upstream := []PickupPlayer{
+		pickupPlayer("1", "A", "a.jpg"),
+		pickupPlayer("2", "B2", "b.jpg"),
+		pickupPlayer("3", "C", "c2.jpg"),
+		pickupPlayer("4", "D", "d.jpg"),
+		pickupPlayer("4", "D", "d.jpg"),
+		pickupPlayer("5", "E", "e.jpg"),
+	}
+
+	p := NewPlan(upstream, stored)
+
+	if got := p.Summary(); got != (Summary{Inserted: 1, Updated: 3, Unchanged: 1, Flagged: 1}) {
+		t.Errorf("got summary %s", got)
+	}
+	wantChanges := []string{`2: renamed "B" to "B2"`, "3: avatar changed", "5: returned upstream"}
+	if !reflect.DeepEqual(p.Changes, wantChanges) {
+		t.Errorf("got changes %q, want %q", p.Changes, wantChanges)
+	}
+	if !reflect.DeepEqual(p.Deletes, []string{"6"}) {
+		t.Errorf("got deletes %v, want only 6, 7 is already flagged", p.Deletes)
+	}
+	if n := len(p.models(time.Now())); n != 5 {
+		t.Errorf("got %d writes, want 5", n)
+	}
+
+	now := time.Date(2021, 10, 20, 0, 0, 0, 0, time.UTC)
+	wantHistory := map[string][]NameRecord{
+		"1": nil,
+		"2": {{Name: "B", Avatar: "b.jpg"}, {Name: "B2", Avatar: "b.jpg", SeenAt: &now}},
+		"3": {{Name: "C", Avatar: "c.jpg"}, {Name: "C", Avatar: "c2.jpg", SeenAt: &now}},
+		"4": {{Name: "D", Avatar: "d.jpg", SeenAt: &now}},
+		"5": nil,
+	}
+	for _, player := range upstream {
+		if got := p.history(player, now); !reflect.DeepEqual(got, wantHistory[player.SteamId]) {
+			t.Errorf("%s: got history %+v, want %+v", player.SteamId, got, wantHistory[player.SteamId])
+		}
+	}
+
+	// players with history aren't backfilled again
+	known := storedPlayer("2", "B", "b.jpg")
+	known.History = []NameRecord{{Name: "B", Avatar: "b.jpg"}}
+	renamed := NewPlan(upstream[1:2], map[string]StoredPlayer{"2": known})
+	if got := renamed.history(upstream[1], now); len(got) != 1 || got[0].Name != "B2" {
+		t.Errorf("got history %+v, want only B2", got)
+	}
+
+	// applying the plan makes the next one empty
+	synced := make(map[string]StoredPlayer)
+	for _, player := range upstream {
+		synced[player.SteamId] = storedPlayer(player.SteamId, player.Name, player.Avatar.Small)
+	}
+	if again := NewPlan(upstream, synced); len(again.models(time.Now())) != 0 || again.Unchanged != 5 {
+		t.Errorf("second plan isn't empty: %+v", again)
+	}
+}
+
+func TestMergeSites(t *testing.T) {
+	onSite := func(p PickupPlayer, site string) PickupPlayer {
+		p.Sites = []string{site}
+		return p
+	}
+	ru := []PickupPlayer{onSite(pickupPlayer("1", "A", "a.jpg"), "ru"), onSite(pickupPlayer("2", "B", "b.jpg"), "ru")}
+	eu := []PickupPlayer{onSite(pickupPlayer("2", "B on eu", "b2.jpg"), "eu"), onSite(pickupPlayer("3", "C", "c.jpg"), "eu")}
+
+	merged := MergeSites([][]PickupPlayer{ru, eu})
+	if len(merged) != 3 {
+		t.Fatalf("got %d players, want 3", len(merged))
+	}
+	if b := merged[1]; b.Name != "B" || !reflect.DeepEqual(b.Sites, []string{"ru", "eu"}) {
+		t.Errorf("got %s on %v, want B from ru on ru and eu", b.Name, b.Sites)
+	}
+
+	b := storedPlayer("2", "B", "b.jpg")
+	b.Sites = []string{"ru"}
+	p := NewPlan(merged[1:2], map[string]StoredPlayer{"2": b})
+	if len(p.Updates) != 1 || p.Changes[0] != "2: sites changed from [ru] to [ru eu]" {
+		t.Errorf("got changes %q, want a sites change", p.Changes)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	players := []StoredPlayer{
+		storedPlayer("1", "A", "a.jpg"),
+		storedPlayer("2", "B", "b.jpg"),
+		storedPlayer("1", "A2", "a.jpg"),
+		storedPlayer("1", "A3", "a.jpg"),
+		storedPlayer("", "no id", ""),
+		storedPlayer("", "no id either", ""),
+	}
+	for i := range players {
+		players[i].ID = i
+	}
+
+	stored, duplicates := dedupe(players)
+	if len(stored) != 2 || stored["1"].Name != "A3" {
+		t.Errorf("got %+v, want the newest copy of 1", stored)
+	}
+	if !reflect.DeepEqual(duplicates, []interface{}{0, 2}) {
+		t.Errorf("got duplicates %v, want 0 and 2", duplicates)
+	}
+
+	p := NewPlan(nil, stored)
+	p.Duplicates = duplicates
+	if got := p.Summary(); got.Removed != 2 {
+		t.Errorf("got summary %s, want 2 removed", got)
+	}
+}
+
+func TestDecodeStored(t *testing.T) {
+	raw, _ := bson.Marshal(bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "steam_id", Value: int64(76561197960287930)},
+		{Key: "name", Value: "A"},
+		{Key: "avatar", Value: "a.jpg"},
+		{Key: "sites", Value: bson.A{"eu", 5}},
+		{Key: "history", Value: bson.A{bson.D{{Key: "name", Value: "B"}}, "C"}},
+	})
+	p, ok := decodeStored(raw)
+	if !ok {
+		t.Fatal("got no player, want one with a numeric steam_id")
+	}
+	if p.SteamID != "76561197960287930" || p.Name != "A" || p.Avatar.Medium != "a.jpg" {
+		t.Errorf("got %+v, want steam_id, name and a plain avatar read", p)
+	}
+	if !reflect.DeepEqual(p.Sites, []string{"eu"}) || len(p.History) != 1 || p.History[0].Name != "B" {
+		t.Errorf("got sites %v and history %+v, want unreadable entries dropped", p.Sites, p.History)
+	}
+
+	raw, _ = bson.Marshal(bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "no id"}})
+	if _, ok = decodeStored(raw); ok {
+		t.Error("got a player without steam_id, want it skipped")
+	}
+}
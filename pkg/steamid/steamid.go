@@ -0,0 +1,77 @@
+// Package steamid parses and converts Steam IDs of individual accounts between
+// SteamID64 (76561197960287930), SteamID3 ([U:1:22202]) and legacy SteamID (STEAM_0:0:11101).
+package steamid
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// base is the SteamID64 of account 0 of the public universe, individual account type.
+const base uint64 = 76561197960265728
+
+var ErrInvalid = errors.New("invalid steamid: must be SteamID64, SteamID3 or STEAM_X:Y:Z")
+
+var (
+	steamID3Pattern = regexp.MustCompile(`^\[?U:1:(\d+)]?$`)
+	steamID2Pattern = regexp.MustCompile(`^STEAM_[01]:([01]):(\d+)$`)
+)
+
+// ID is a SteamID64 of an individual account.
+type ID uint64
+
+// FromAccountID returns the ID of a 32-bit account number, the last part of a SteamID3.
+func FromAccountID(account uint32) ID {
+	return ID(base + uint64(account))
+}
+
+// Parse reads an ID in any of the three formats. SteamID3 brackets are optional
+// and the universe digit of a legacy SteamID is ignored, games print it as 0 or 1.
+func Parse(s string) (ID, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+	if m := steamID3Pattern.FindStringSubmatch(upper); m != nil {
+		account, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w, got %q", ErrInvalid, s)
+		}
+		return FromAccountID(uint32(account)), nil
+	}
+	if m := steamID2Pattern.FindStringSubmatch(upper); m != nil {
+		half, err := strconv.ParseUint(m[2], 10, 31)
+		if err != nil {
+			return 0, fmt.Errorf("%w, got %q", ErrInvalid, s)
+		}
+		return FromAccountID(uint32(half*2) + uint32(m[1][0]-'0')), nil
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id < base || id-base > 1<<32-1 {
+		return 0, fmt.Errorf("%w, got %q", ErrInvalid, s)
+	}
+	return ID(id), nil
+}
+
+// AccountID is the 32-bit account number.
+func (id ID) AccountID() uint32 {
+	return uint32(uint64(id) - base)
+}
+
+// String formats the ID as SteamID64.
+func (id ID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// SteamID3 formats the ID as [U:1:account].
+func (id ID) SteamID3() string {
+	return fmt.Sprintf("[U:1:%d]", id.AccountID())
+}
+
+// SteamID2 formats the ID as legacy STEAM_0:Y:Z, where account = Z*2 + Y.
+func (id ID) SteamID2() string {
+	account := id.AccountID()
+	return fmt.Sprintf("STEAM_0:%d:%d", account&1, account>>1)
+}
@@ -0,0 +1,51 @@
+package steamid
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ID
+	}{
+		{"76561197960287930", 76561197960287930},
+		{"[U:1:22202]", 76561197960287930},
+		{"U:1:22202", 76561197960287930},
+		{"[u:1:22202]", 76561197960287930},
+		{"STEAM_0:0:11101", 76561197960287930},
+		{"STEAM_1:0:11101", 76561197960287930},
+		{" steam_0:1:11101 ", 76561197960287931},
+		{"[U:1:0]", 76561197960265728},
+		{"[U:1:4294967295]", 76561202255233023},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil || got != tt.want {
+				t.Errorf("got %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "abc", "123", "76561197960265727", "76561202255233024", "[U:1:4294967296]", "[G:1:5]", "STEAM_0:2:1", "STEAM_0:0:"} {
+		if _, err := Parse(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, id := range []ID{76561197960287930, 76561197960287931, 76561198012345678} {
+		for _, s := range []string{id.String(), id.SteamID3(), id.SteamID2()} {
+			if got, err := Parse(s); err != nil || got != id {
+				t.Errorf("%s doesn't parse back to %d: got %d, %v", s, id, got, err)
+			}
+		}
+	}
+	id := ID(76561197960287931)
+	if id.SteamID3() != "[U:1:22203]" || id.SteamID2() != "STEAM_0:1:11101" || id.AccountID() != 22203 {
+		t.Errorf("got %s, %s, %d", id.SteamID3(), id.SteamID2(), id.AccountID())
+	}
+}
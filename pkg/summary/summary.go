@@ -0,0 +1,216 @@
+package summary
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"PickupStats/pkg/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Summarizer maintains per-player-per-class totals of every metric field,
+// so ratings don't have to aggregate the whole games collection.
+//
+// Summary documents look like
+//
+//	{_id: {steam_id, class}, steam_id, class, games, sums: {stats_kills: ..., length: ...}, batch, updated_at}
+//
+// Summarized games are marked with the batch they were folded in, see db.SummaryBatchField.
+type Summarizer struct {
+	games, summaries, state *mongo.Collection
+}
+
+func New(conn *mongo.Client, database, gamesCollection, summariesCollection string) *Summarizer {
+	d := conn.Database(database)
+	return &Summarizer{
+		games:     d.Collection(gamesCollection),
+		summaries: d.Collection(summariesCollection),
+		state:     d.Collection(db.SummaryStateCollection(summariesCollection)),
+	}
+}
+
+// Update folds games not summarized yet into summaries and returns the number of
+// games processed. Summaries that were never built, built by an older version or with
+// other fields than db.SummaryFields, are rebuilt.
+//
+// New games are marked with a batch ID before they are merged, so games inserted
+// with an older _id aren't missed. A batch left pending by a failed update is merged
+// again before new games are marked; summaries already holding it are left as they are.
+func (s *Summarizer) Update(ctx context.Context) (int64, error) {
+	state, err := s.loadState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if state == nil || state.Batch.IsZero() || !state.HasFields() {
+		return s.Rebuild(ctx)
+	}
+	if err = s.ensureIndex(ctx); err != nil {
+		return 0, err
+	}
+
+	batch := primitive.NewObjectID()
+	if state.Pending != nil {
+		batch = *state.Pending
+	} else {
+		state.Pending = &batch
+		if err = s.saveState(ctx, *state); err != nil {
+			return 0, err
+		}
+		if err = s.mark(ctx, batch); err != nil {
+			return 0, err
+		}
+	}
+
+	match := bson.D{{Key: db.SummaryBatchField, Value: batch}}
+	count, err := s.games.CountDocuments(ctx, match)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		now := time.Now().UTC()
+		p := append(summaryPipeline(match, batch, now), bson.D{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: s.summaries.Name()},
+			{Key: "on", Value: "_id"},
+			{Key: "whenMatched", Value: mergeSums()},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}})
+		if err = s.run(ctx, p); err != nil {
+			return 0, err
+		}
+		state.UpdatedAt = now
+	}
+	state.Batch, state.Pending = batch, nil
+	return count, s.saveState(ctx, *state)
+}
+
+// Rebuild recomputes summaries from all games, replacing the collection.
+func (s *Summarizer) Rebuild(ctx context.Context) (int64, error) {
+	// without a state the next update starts an interrupted rebuild over
+	if _, err := s.state.DeleteOne(ctx, bson.D{{Key: "_id", Value: db.SummaryStateID}}); err != nil {
+		return 0, err
+	}
+	if err := s.ensureIndex(ctx); err != nil {
+		return 0, err
+	}
+	batch := primitive.NewObjectID()
+	if err := s.mark(ctx, batch); err != nil {
+		return 0, err
+	}
+
+	// games inserted after marking are left to the next update
+	match := bson.D{{Key: db.SummaryBatchField, Value: bson.D{{Key: "$ne", Value: nil}}}}
+	count, err := s.games.CountDocuments(ctx, match)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	p := append(summaryPipeline(match, batch, now), bson.D{{Key: "$out", Value: s.summaries.Name()}})
+	if err = s.run(ctx, p); err != nil {
+		return 0, err
+	}
+	return count, s.saveState(ctx, db.SummaryState{ID: db.SummaryStateID, Batch: batch, Fields: db.SummaryFields(), UpdatedAt: now})
+}
+
+// mark assigns games not summarized yet to the batch.
+func (s *Summarizer) mark(ctx context.Context, batch primitive.ObjectID) error {
+	_, err := s.games.UpdateMany(ctx,
+		bson.D{{Key: db.SummaryBatchField, Value: nil}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: db.SummaryBatchField, Value: batch}}}},
+	)
+	return err
+}
+
+// ensureIndex lets updates find unmarked games without a collection scan.
+func (s *Summarizer) ensureIndex(ctx context.Context) error {
+	_, err := s.games.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: db.SummaryBatchField, Value: 1}}})
+	return err
+}
+
+func (s *Summarizer) run(ctx context.Context, p mongo.Pipeline) error {
+	cur, err := s.games.Aggregate(ctx, p, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cur.Close(ctx)
+}
+
+// summaryPipeline groups matched games of a batch into summary documents.
+func summaryPipeline(match bson.D, batch primitive.ObjectID, now time.Time) mongo.Pipeline {
+	totals := bson.D{{Key: "games", Value: bson.D{{Key: "$sum", Value: 1}}}}
+	sums := bson.D{}
+	for _, f := range db.SummaryFields() {
+		key := db.SummaryKey(f)
+		totals = append(totals, bson.E{Key: key, Value: bson.D{{Key: "$sum", Value: "$" + f}}})
+		sums = append(sums, bson.E{Key: key, Value: "$" + key})
+	}
+
+	return db.NewPipeline().
+		Match(match).
+		Group(bson.D{
+			{Key: "steam_id", Value: "$player.steam_id"},
+			{Key: "class", Value: "$player.class"},
+		}, totals).
+		Project(bson.D{
+			{Key: "steam_id", Value: "$_id.steam_id"},
+			{Key: "class", Value: "$_id.class"},
+			{Key: "games", Value: 1},
+			{Key: "sums", Value: sums},
+			{Key: "batch", Value: bson.D{{Key: "$literal", Value: batch}}},
+			{Key: "updated_at", Value: bson.D{{Key: "$literal", Value: now}}},
+		}).
+		Build()
+}
+
+// mergeSums adds totals of new games to an existing summary, unless the summary
+// already holds the batch, so merging a batch again doesn't count its games twice.
+func mergeSums() mongo.Pipeline {
+	fresh := bson.D{{Key: "$ne", Value: bson.A{"$batch", "$$new.batch"}}}
+	add := func(field string) bson.D {
+		return bson.D{{Key: "$cond", Value: bson.A{
+			fresh,
+			bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$" + field, 0}}},
+				"$$new." + field,
+			}}},
+			"$" + field,
+		}}}
+	}
+	set := bson.D{
+		{Key: "games", Value: add("games")},
+		{Key: "updated_at", Value: bson.D{{Key: "$cond", Value: bson.A{fresh, "$$new.updated_at", "$updated_at"}}}},
+	}
+	for _, f := range db.SummaryFields() {
+		field := "sums." + db.SummaryKey(f)
+		set = append(set, bson.E{Key: field, Value: add(field)})
+	}
+	set = append(set, bson.E{Key: "batch", Value: "$$new.batch"})
+	return mongo.Pipeline{{{Key: "$set", Value: set}}}
+}
+
+func (s *Summarizer) loadState(ctx context.Context) (*db.SummaryState, error) {
+	var state db.SummaryState
+	err := s.state.FindOne(ctx, bson.D{{Key: "_id", Value: db.SummaryStateID}}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *Summarizer) saveState(ctx context.Context, state db.SummaryState) error {
+	state.ID = db.SummaryStateID
+	_, err := s.state.ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: db.SummaryStateID}},
+		state,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
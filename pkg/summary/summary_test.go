@@ -0,0 +1,32 @@
+package summary
+
+import (
+	"testing"
+
+	"PickupStats/pkg/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMergeSumsSkipsMergedBatch(t *testing.T) {
+	set := mergeSums()[0][0].Value.(bson.D)
+	fields := make(map[string]bool)
+	for _, e := range set {
+		fields[e.Key] = true
+		if e.Key == "batch" {
+			continue
+		}
+		cond, ok := e.Value.(bson.D)
+		if !ok || len(cond) != 1 || cond[0].Key != "$cond" {
+			t.Errorf("%s is set without checking the batch: %v", e.Key, e.Value)
+		}
+	}
+	for _, f := range db.SummaryFields() {
+		if !fields["sums."+db.SummaryKey(f)] {
+			t.Errorf("sum of %s isn't merged", f)
+		}
+	}
+	if !fields["batch"] {
+		t.Error("merged batch isn't recorded")
+	}
+}
@@ -2,41 +2,20 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
-	"time"
 
 	"PickupStats/pkg/config"
 	"PickupStats/pkg/db"
+	"PickupStats/pkg/resolver"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/sirupsen/logrus"
 )
 
-const playerEndpoint = "http://api.tf2pickup.ru/players"
-
-type PickupPlayer struct {
-	SteamId string `json:"steamId" bson:"steam_id"`
-	Name    string `json:"name" bson:"name"`
-	Avatar  struct {
-		Small  string `json:"small" bson:"small"`
-		Medium string `json:"medium" bson:"medium"`
-		Large  string `json:"large" bson:"large"`
-	} `json:"avatar" bson:"avatar"`
-	Roles          []string  `json:"roles" bson:"roles"`
-	Etf2LProfileId int       `json:"etf2lProfileId" bson:"etf2l_profile_id"`
-	JoinedAt       time.Time `json:"joinedAt" bson:"joined_at"`
-	Id             string    `json:"id" bson:"id"`
-	Links          []struct {
-		Href  string `json:"href" bson:"href"`
-		Title string `json:"title" bson:"title"`
-	} `json:"_links" bson:"links"`
-}
-
 func main() {
 	configPath := flag.String("config", "config.yaml", "path to config file")
+	dryRun := flag.Bool("dry-run", false, "print what would change without writing")
 	flag.Parse()
 
 	ctx := context.Background()
@@ -45,44 +24,31 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
-	client, err := db.NewClient(ctx, cfg.DSN, cfg.Database, cfg.GameCollection, cfg.NameCollection)
-	if err != nil {
-		log.Fatalf("Failed to init mongo client: %v", err)
+	if err = cfg.Validate(); err != nil {
+		log.Fatalln(err)
 	}
-	players, err := GetPlayers()
+	client, err := db.NewClient(ctx, cfg.DSN, cfg.Database, cfg.GameCollection, cfg.NameCollection, cfg.SummaryCollection)
 	if err != nil {
-		log.Fatalf("Failed to get players from API: %v", err)
-	}
-
-	log.Printf("Got %d players\n", len(players))
-
-	for _, player := range players {
-		log.Println("Processing player " + player.Name)
-		bytes, err := bson.Marshal(player)
-		if err != nil {
-			log.Fatalf("Failed to marshall player: %v", err)
-		}
-		res, err := client.Conn.Database(cfg.Database).Collection("player_names_v2").InsertOne(ctx, bytes)
-		if err != nil {
-			log.Fatalf("Failed to insert player: %v", err)
-		}
-		log.Println("ID: ", res.InsertedID)
+		log.Fatalf("Failed to init mongo client: %v", err)
 	}
-	log.Println("Finished successfully")
-}
 
-func GetPlayers() ([]PickupPlayer, error) {
-	players := make([]PickupPlayer, 0)
-	resp, err := http.Get(playerEndpoint)
+	names := client.Conn.Database(cfg.Database).Collection(cfg.NameCollection)
+	r := resolver.New(names, cfg.Sites, resolver.Fetcher{
+		Client:  &http.Client{Timeout: cfg.Resolver.Timeout},
+		Retries: cfg.Resolver.Retries,
+		Backoff: cfg.Resolver.Backoff,
+	})
+	summary, err := r.SyncOnce(ctx, *dryRun, logrus.StandardLogger())
 	if err != nil {
-		return nil, err
+		log.Fatalf("Failed to sync players: %v", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("api returned http code: %d", resp.StatusCode)
+	if *dryRun {
+		log.Println("Dry run, would do: " + summary.String())
+		return
 	}
-	if err = json.NewDecoder(resp.Body).Decode(&players); err != nil {
-		return nil, err
+	log.Println("Synced: " + summary.String())
+	if summary.Failed > 0 {
+		log.Fatalln("Finished with errors")
 	}
-	return players, nil
+	log.Println("Finished successfully")
 }
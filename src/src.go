@@ -0,0 +1,7 @@
+// Package src embeds frontend templates, styles and images into the binary.
+package src
+
+import "embed"
+
+//go:embed templates css img
+var FS embed.FS
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"PickupStats/pkg/config"
+	"PickupStats/pkg/db"
+	"PickupStats/pkg/summary"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	rebuild := flag.Bool("rebuild", false, "recompute summaries from all games")
+	interval := flag.Duration("interval", 0, "keep updating summaries with this interval, run once if zero")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if cfg.SummaryCollection == "" {
+		log.Fatalf("summaryCollection is not set in config")
+	}
+	client, err := db.NewClient(ctx, cfg.DSN, cfg.Database, cfg.GameCollection, cfg.NameCollection, cfg.SummaryCollection)
+	if err != nil {
+		log.Fatalf("Failed to init mongo client: %v", err)
+	}
+	s := summary.New(client.Conn, cfg.Database, cfg.GameCollection, cfg.SummaryCollection)
+
+	if *rebuild {
+		count, err := s.Rebuild(ctx)
+		if err != nil {
+			log.Fatalf("Failed to rebuild summaries: %v", err)
+		}
+		log.Printf("Rebuilt summaries from %d games\n", count)
+	}
+
+	for {
+		count, err := s.Update(ctx)
+		if err != nil {
+			log.Printf("Failed to update summaries: %v", err)
+		} else {
+			log.Printf("Summarized %d new games\n", count)
+		}
+		if *interval == 0 {
+			if err != nil {
+				log.Fatalln("Finished with errors")
+			}
+			break
+		}
+		time.Sleep(*interval)
+	}
+	log.Println("Finished successfully")
+}